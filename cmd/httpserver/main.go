@@ -1,39 +1,86 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
-	"errors"
 	"fmt"
-	"io"
+	"hash"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
+	"httpfromtcp/internal/fileserver"
 	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/middleware"
+	"httpfromtcp/internal/proxy"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/router"
 	"httpfromtcp/internal/server"
 )
 
 const port = 42069
 
+// shutdownTimeout is how long in-flight requests get to finish on SIGTERM.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	server, err := server.Serve(port, handleVideoFunc)
+	httpbin, err := proxy.New("https://httpbin.org")
+	if err != nil {
+		log.Fatalf("Error configuring proxy: %v", err)
+	}
+	httpbin.Trailer = newBodyDigest
+
+	rt := router.New()
+	rt.Handle("GET /video", handleVideoFunc)
+	rt.Mount("/httpbin", httpbin.Serve)
+
+	handler := server.Chain(rt.Serve,
+		middleware.Logger(nil),
+		middleware.Recoverer(nil),
+		middleware.RequestID,
+		middleware.Compress,
+	)
+
+	srv, err := start(handler)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	log.Println("Server started on", srv.Addr)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
 
+// start serves handler on the socket passed by systemd socket activation if
+// there is one, or else on the address in $ADDR, localhost:port by default.
+func start(handler server.Handler) (*server.Server, error) {
+	listeners, err := server.SystemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		return server.ServeListener(listeners[0], handler)
+	}
+
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = fmt.Sprintf("localhost:%d", port)
+	}
+	return server.ServeAddr("tcp", addr, handler)
+}
+
 // func handlerFunc(w *response.Writer, req *request.Request) {
 // 	res := []byte(`<html>
 //   <head>
@@ -77,81 +124,29 @@ func main() {
 // 	w.WriteBody(res)
 // }
 
-func HandlerFunc(w *response.Writer, req *request.Request) {
-	if !strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		w.WriteStatusLine(response.StatusOK)
-		w.WriteHeaders(response.GetDefaultHeaders(0))
-		w.WriteBody([]byte{})
-		return
-	}
-
-	path := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
+// bodyDigest sends the SHA-256 and length of a proxied body as trailers.
+type bodyDigest struct {
+	hash   hash.Hash
+	length int
+}
 
-	res, err := http.Get("https://httpbin.org/" + path)
-	if err != nil {
-		w.WriteStatusLine(response.StatusInternal)
-		res := []byte("Error fetching httpbin")
-		w.WriteHeaders(response.GetDefaultHeaders(len(res)))
-		w.WriteBody(res)
-		return
-	}
+func newBodyDigest(h *headers.Headers) proxy.Trailer {
+	h.Set("trailer", "X-Content-Sha256, X-Content-Length")
+	return &bodyDigest{hash: sha256.New()}
+}
 
-	w.WriteStatusLine(response.StatusOK)
-	h := response.GetDefaultHeaders(0)
-	delete(h, "content-length")
-	h["host"] = "httpbin.org"
-	h["transfer-encoding"] = "chunked"
-	h["Trailer"] = "X-Content-Sha256, X-Content-Length"
-	w.WriteHeaders(h)
-
-	defer res.Body.Close()
-
-	buf := make([]byte, 1024)
-
-	resBody := make([]byte, 0)
-
-	for {
-		n, err := res.Body.Read(buf)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				w.WriteChunkedBodyDone()
-				break
-			}
-			break
-		}
-		resBody = append(resBody, buf[:n]...)
-		w.WriteChunkedBody(buf[:n])
-	}
+func (d *bodyDigest) Write(p []byte) (int, error) {
+	d.length += len(p)
+	return d.hash.Write(p)
+}
 
-	shaValue := sha256.Sum256(resBody)
-	trailers := headers.Headers{
-		"X-Content-Sha256": fmt.Sprintf("%x", shaValue),
-		"X-Content-Length": fmt.Sprintf("%d", len(resBody)),
-	}
-	w.WriteTrailers(trailers)
+func (d *bodyDigest) Trailers() *headers.Headers {
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-Sha256", fmt.Sprintf("%x", d.hash.Sum(nil)))
+	trailers.Set("X-Content-Length", fmt.Sprintf("%d", d.length))
+	return trailers
 }
 
 func handleVideoFunc(w *response.Writer, req *request.Request) {
-	if req.RequestLine.RequestTarget != "/video" {
-		w.WriteStatusLine(response.StatusBadRequest)
-		res := []byte("Not Found")
-		w.WriteHeaders(response.GetDefaultHeaders(len(res)))
-		w.WriteBody(res)
-		return
-	}
-
-	videoBuff, err := os.ReadFile("assets/vim.mp4")
-	if err != nil {
-		w.WriteStatusLine(response.StatusInternal)
-		res := []byte("file not found")
-		w.WriteHeaders(response.GetDefaultHeaders(len(res)))
-		w.WriteBody(res)
-		return
-	}
-
-	w.WriteStatusLine(response.StatusOK)
-	headers := response.GetDefaultHeaders(len(videoBuff))
-	headers["content-type"] = "video/mp4"
-	w.WriteHeaders(headers)
-	w.WriteBody(videoBuff)
+	fileserver.ServeFile(w, req, "assets/vim.mp4")
 }
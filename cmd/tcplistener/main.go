@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net"
 
@@ -38,10 +39,14 @@ func main() {
 		fmt.Printf("Request line:\n- Method: %s\n- Target: %s\n- Version: %s\n",
 			req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion)
 		fmt.Println("Headers:")
-		for k, v := range req.Headers {
+		for k, v := range req.Headers.All() {
 			fmt.Printf("- %s: %s\n", k, v)
 		}
-		fmt.Printf("Body:\n%s\n", string(req.Body))
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			fmt.Println("Error reading body:", err)
+		}
+		fmt.Printf("Body:\n%s\n", string(body))
 
 		conn.Close()
 		fmt.Println("Connection closed")
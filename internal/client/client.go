@@ -0,0 +1,289 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/response"
+)
+
+const (
+	// DefaultDialTimeout bounds the time to connect to a server.
+	DefaultDialTimeout = 30 * time.Second
+	// DefaultIdleConnTimeout is how long an idle connection is kept for
+	// reuse.
+	DefaultIdleConnTimeout = 90 * time.Second
+	// DefaultMaxIdleConnsPerHost is how many idle connections are kept for
+	// each server.
+	DefaultMaxIdleConnsPerHost = 2
+)
+
+var errBodyClosed = errors.New("read on closed body")
+
+// Client sends requests over HTTP/1.1, keeping connections alive between
+// requests to the same server. Its configuration must be set before its first
+// request and left alone afterwards.
+type Client struct {
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout bounds the time to write a request and read the
+	// headers of its response. Zero means no timeout.
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConnsPerHost   int
+	// TLSConfig configures https connections. It is cloned for each
+	// connection with ServerName set from the URL.
+	TLSConfig *tls.Config
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+// conn is a connection to a server, with the reader parsing its responses.
+type conn struct {
+	net.Conn
+	rr        *response.Reader
+	key       string
+	idleSince time.Time
+}
+
+// DefaultClient is the client used by Get.
+var DefaultClient = New()
+
+// New returns a client with the default timeouts and pool size.
+func New() *Client {
+	return &Client{
+		DialTimeout:         DefaultDialTimeout,
+		IdleConnTimeout:     DefaultIdleConnTimeout,
+		MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+	}
+}
+
+// Get sends a GET request for rawURL with DefaultClient.
+func Get(rawURL string) (*response.Response, error) {
+	return DefaultClient.Get(rawURL)
+}
+
+// Get sends a GET request for rawURL.
+func (c *Client) Get(rawURL string) (*response.Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req and reads the headers of its response. The caller must read
+// the response body to io.EOF or close it.
+//
+// A request without a body that fails on a pooled connection, which the
+// server may have closed in the meantime, is retried once on a new one.
+func (c *Client) Do(req *Request) (*response.Response, error) {
+	key, addr, useTLS := target(req)
+
+	for retried := false; ; retried = true {
+		cn, reused, err := c.getConn(key, addr, req.URL.Hostname(), useTLS)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := c.roundTrip(cn, req)
+		if err != nil {
+			cn.Close()
+			if reused && !retried && req.Body == nil {
+				continue
+			}
+			return nil, err
+		}
+		return res, nil
+	}
+}
+
+func (c *Client) roundTrip(cn *conn, req *Request) (*response.Response, error) {
+	if c.ResponseHeaderTimeout > 0 {
+		cn.SetDeadline(time.Now().Add(c.ResponseHeaderTimeout))
+	}
+
+	if err := writeRequest(cn, req); err != nil {
+		return nil, err
+	}
+	res, err := cn.rr.ReadResponse(req.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	cn.SetDeadline(time.Time{})
+
+	b := &body{src: res.Body, client: c, conn: cn, reusable: reusable(req, res)}
+	if res.Body == response.NoBody {
+		// nothing left to read, the connection is free already
+		b.release(true)
+	}
+	res.Body = b
+	return res, nil
+}
+
+// reusable reports whether the connection can carry another request once the
+// response body is read.
+func reusable(req *Request, res *response.Response) bool {
+	if res.CloseDelimited() || res.StatusLine.StatusCode == response.StatusSwitchingProtocols {
+		return false
+	}
+	if hasToken(req.Headers.Values("connection"), "close") || hasToken(res.Headers.Values("connection"), "close") {
+		return false
+	}
+	if res.StatusLine.HttpVersion == "1.0" {
+		return hasToken(res.Headers.Values("connection"), "keep-alive")
+	}
+	return true
+}
+
+func hasToken(values []string, token string) bool {
+	for _, value := range values {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// target returns the pool key and dial address for the server of req.
+func target(req *Request) (string, string, bool) {
+	useTLS := req.URL.Scheme == "https"
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if useTLS {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(req.URL.Hostname(), port)
+	return req.URL.Scheme + "://" + addr, addr, useTLS
+}
+
+// getConn returns an idle connection to the server, or dials a new one. It
+// reports whether the connection was reused.
+func (c *Client) getConn(key, addr, host string, useTLS bool) (*conn, bool, error) {
+	c.mu.Lock()
+	for len(c.idle[key]) > 0 {
+		conns := c.idle[key]
+		cn := conns[len(conns)-1]
+		c.idle[key] = conns[:len(conns)-1]
+		if c.IdleConnTimeout > 0 && time.Since(cn.idleSince) > c.IdleConnTimeout {
+			cn.Close()
+			continue
+		}
+		c.mu.Unlock()
+		return cn, true, nil
+	}
+	c.mu.Unlock()
+
+	dialer := &net.Dialer{Timeout: c.DialTimeout}
+	var nc net.Conn
+	var err error
+	if useTLS {
+		config := &tls.Config{}
+		if c.TLSConfig != nil {
+			config = c.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = host
+		}
+		config.NextProtos = []string{"http/1.1"}
+		nc, err = tls.DialWithDialer(dialer, "tcp", addr, config)
+	} else {
+		nc, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &conn{Conn: nc, rr: response.NewReader(nc), key: key}, false, nil
+}
+
+// putConn keeps cn for another request, or closes it if the pool is full.
+func (c *Client) putConn(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle[cn.key]) >= c.MaxIdleConnsPerHost {
+		cn.Close()
+		return
+	}
+	if c.idle == nil {
+		c.idle = map[string][]*conn{}
+	}
+	cn.idleSince = time.Now()
+	c.idle[cn.key] = append(c.idle[cn.key], cn)
+}
+
+// CloseIdleConnections closes the connections kept for reuse.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, conns := range c.idle {
+		for _, cn := range conns {
+			cn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+// body hands the connection back to the client once the response body is
+// read, or closes it if that is not possible.
+type body struct {
+	src      io.ReadCloser
+	client   *Client
+	conn     *conn
+	reusable bool
+	released bool
+	closed   bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	n, err := b.src.Read(p)
+	if err == io.EOF {
+		b.release(true)
+	} else if err != nil {
+		b.release(false)
+	}
+	return n, err
+}
+
+// Close discards what is left of the body so the connection can be reused,
+// or closes the connection if too much is left.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if b.released {
+		return nil
+	}
+
+	// the response body drains what is left, if it is not too much
+	b.release(b.reusable && b.src.Close() == nil)
+	return nil
+}
+
+func (b *body) release(done bool) {
+	if b.released {
+		return
+	}
+	b.released = true
+	if done && b.reusable {
+		b.client.putConn(b.conn)
+		return
+	}
+	b.conn.Close()
+}
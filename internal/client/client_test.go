@@ -0,0 +1,195 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func handler(w *response.Writer, req *request.Request) {
+	switch req.Target.Path {
+	case "/chunked":
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.NewHeaders("transfer-encoding", "chunked", "trailer", "x-sum"))
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.NewHeaders("x-sum", "abc"))
+	case "/close":
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.NewHeaders("content-type", "text/plain"))
+		w.Write([]byte("until close"))
+	case "/not-modified":
+		w.WriteStatusLine(response.StatusNotModified)
+		w.WriteHeaders(headers.NewHeaders("etag", `"v1"`))
+	case "/echo":
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		w.WriteStatusLine(response.StatusCreated)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	default:
+		res := []byte(req.RequestLine.RequestTarget)
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(len(res))
+		h.Add("x-multi", "a")
+		h.Add("x-multi", "b")
+		w.WriteHeaders(h)
+		w.WriteBody(res)
+	}
+}
+
+func start(t *testing.T, srv *server.Server) (*countingListener, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	counting := &countingListener{Listener: ln}
+	require.NoError(t, srv.StartListener(counting))
+	t.Cleanup(func() { srv.Close() })
+	return counting, "http://" + srv.Addr
+}
+
+func TestClient(t *testing.T) {
+	ln, base := start(t, server.New(handler))
+	c := New()
+	defer c.CloseIdleConnections()
+
+	// Test: Content-length response
+	res, err := c.Get(base + "/path?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", res.StatusLine.HttpVersion)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "OK", res.StatusLine.Reason)
+	assert.Equal(t, []string{"a", "b"}, res.Headers.Values("x-multi"))
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "/path?q=1", string(body))
+	require.NoError(t, res.Body.Close())
+
+	// Test: Chunked response with trailers, on the same connection
+	res, err = c.Get(base + "/chunked")
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, []string{"abc"}, res.Trailers.Values("x-sum"))
+	assert.Equal(t, int32(1), ln.accepted.Load())
+
+	// Test: Response without a body by definition
+	res, err = c.Get(base + "/not-modified")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusNotModified, res.StatusLine.StatusCode)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	req, err := NewRequest("HEAD", base+"/head", nil)
+	require.NoError(t, err)
+	res, err = c.Do(req)
+	require.NoError(t, err)
+	v, _ := res.Headers.Get("content-length")
+	assert.Equal(t, "5", v)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+	assert.Equal(t, int32(1), ln.accepted.Load())
+
+	// Test: Body of known length and chunked body
+	req, err = NewRequest("POST", base+"/echo", strings.NewReader("sized body"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), req.ContentLength)
+	res, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCreated, res.StatusLine.StatusCode)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "sized body", string(body))
+
+	req, err = NewRequest("POST", base+"/echo", io.MultiReader(strings.NewReader("streamed "), bytes.NewReader([]byte("body"))))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), req.ContentLength)
+	res, err = c.Do(req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "streamed body", string(body))
+	assert.Equal(t, int32(1), ln.accepted.Load())
+
+	// Test: Unread body is drained on close and the connection kept
+	res, err = c.Get(base + "/unread")
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	_, err = res.Body.Read(make([]byte, 1))
+	assert.Error(t, err)
+	res, err = c.Get(base + "/again")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, int32(1), ln.accepted.Load())
+
+	// Test: Close-delimited response cannot reuse its connection
+	res, err = c.Get(base + "/close")
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "until close", string(body))
+	res, err = c.Get(base + "/after-close")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, int32(2), ln.accepted.Load())
+
+	// Test: Invalid URLs are refused
+	_, err = c.Get("ftp://localhost/")
+	assert.Error(t, err)
+	_, err = c.Get("/relative")
+	assert.Error(t, err)
+}
+
+func TestClientStaleConnection(t *testing.T) {
+	srv := server.New(handler)
+	srv.IdleTimeout = 50 * time.Millisecond
+	ln, base := start(t, srv)
+	c := New()
+	defer c.CloseIdleConnections()
+
+	res, err := c.Get(base + "/first")
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	// Test: Request on a connection closed by the server is retried
+	time.Sleep(200 * time.Millisecond)
+	res, err = c.Get(base + "/second")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", string(body))
+	assert.Equal(t, int32(2), ln.accepted.Load())
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+)
+
+// Request is a request to be sent by a Client.
+type Request struct {
+	Method string
+	// URL is the absolute http or https URL of the resource.
+	URL *url.URL
+	// Headers are sent in order. Host is filled in from URL unless set, and
+	// framing headers are set from Body and ContentLength.
+	Headers *headers.Headers
+	// Body is sent as the request body if not nil.
+	Body io.Reader
+	// ContentLength is the length of Body, or -1 if it is unknown, in which
+	// case the body is sent chunked.
+	ContentLength int64
+}
+
+// NewRequest returns a request for method and rawURL. The content length is
+// worked out for bodies of a known size, such as a *bytes.Reader.
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host in URL")
+	}
+
+	req := &Request{
+		Method:  method,
+		URL:     u,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}
+
+	switch b := body.(type) {
+	case nil:
+	case *bytes.Reader:
+		req.ContentLength = int64(b.Len())
+	case *bytes.Buffer:
+		req.ContentLength = int64(b.Len())
+	case *strings.Reader:
+		req.ContentLength = int64(b.Len())
+	default:
+		req.ContentLength = -1
+	}
+
+	return req, nil
+}
+
+// writeRequest writes req to w in wire format.
+func writeRequest(w io.Writer, req *Request) error {
+	h := headers.NewHeaders()
+	if _, ok := req.Headers.Get("host"); !ok {
+		h.Set("host", req.URL.Host)
+	}
+	for k, v := range req.Headers.All() {
+		// framing is derived from the body, not taken from the caller
+		if strings.EqualFold(k, "content-length") || strings.EqualFold(k, "transfer-encoding") {
+			continue
+		}
+		h.Add(k, v)
+	}
+
+	switch {
+	case req.Body != nil && req.ContentLength < 0:
+		h.Set("transfer-encoding", "chunked")
+	case req.Body != nil || methodWithBody(req.Method):
+		h.Set("content-length", strconv.FormatInt(max(req.ContentLength, 0), 10))
+	}
+
+	out := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        req.Method,
+			RequestTarget: req.URL.RequestURI(),
+			HttpVersion:   "1.1",
+		},
+		Headers: h,
+	}
+	if req.Body != nil {
+		out.Body = io.NopCloser(req.Body)
+	}
+
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// methodWithBody reports whether requests with method are expected to carry
+// a body, and so need a content-length even when it is empty.
+func methodWithBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
@@ -0,0 +1,205 @@
+package conditional
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// TimeFormat is the format of HTTP dates, such as Last-Modified.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsolete date formats recipients must still accept
+const (
+	rfc850Format  = "Monday, 02-Jan-06 15:04:05 GMT"
+	asctimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+// ContentETag returns a strong entity tag derived from the content itself.
+func ContentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// FileETag returns an entity tag derived from the modification time and size
+// of a file, which is cheap to compute for large files.
+func FileETag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// Weak returns the weak version of etag, for representations that are
+// equivalent but not byte for byte identical.
+func Weak(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// FormatTime formats t as an HTTP date.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// ParseTime parses an HTTP date in any of the formats allowed by RFC 9110.
+func ParseTime(s string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{TimeFormat, rfc850Format, asctimeFormat} {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// SetValidators sets the ETag and Last-Modified headers of a response. An
+// empty etag or zero modTime is left out.
+func SetValidators(h *headers.Headers, etag string, modTime time.Time) {
+	if etag != "" {
+		h.Set("etag", etag)
+	}
+	if !modTime.IsZero() {
+		h.Set("last-modified", FormatTime(modTime))
+	}
+}
+
+// Check evaluates the preconditions of req against the current validators of
+// the target resource, in the order set by RFC 9110. It returns
+// response.StatusNotModified or response.StatusPreconditionFailed if the
+// request must be answered with that status instead of being served, or 0 if
+// it can go on. An empty etag or zero modTime means the resource has none.
+func Check(req *request.Request, etag string, modTime time.Time) response.StatusCode {
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+	modTime = modTime.Truncate(time.Second)
+
+	if ifMatch, ok := req.Headers.Get("if-match"); ok {
+		if !matchETag(ifMatch, etag, true) {
+			return response.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(req, "if-unmodified-since"); ok && !modTime.IsZero() {
+		if modTime.After(since) {
+			return response.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch, ok := req.Headers.Get("if-none-match"); ok {
+		if matchETag(ifNoneMatch, etag, false) {
+			if safe {
+				return response.StatusNotModified
+			}
+			return response.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(req, "if-modified-since"); ok && safe && !modTime.IsZero() {
+		if !modTime.After(since) {
+			return response.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// RangeApplies reports whether the Range header of req should be honoured:
+// there is no If-Range, or it still matches the resource, by a strong entity
+// tag or the exact modification time.
+func RangeApplies(req *request.Request, etag string, modTime time.Time) bool {
+	ifRange, ok := req.Headers.Get("if-range")
+	if !ok {
+		return true
+	}
+	ifRange = strings.TrimSpace(ifRange)
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return !isWeak(ifRange) && !isWeak(etag) && ifRange == etag
+	}
+	t, err := ParseTime(ifRange)
+	return err == nil && !modTime.IsZero() && modTime.Truncate(time.Second).Equal(t)
+}
+
+// Serve answers req with a 304 or 412 if its preconditions require it, and
+// reports whether it did. Otherwise the caller serves the request as usual.
+func Serve(w *response.Writer, req *request.Request, etag string, modTime time.Time) bool {
+	switch Check(req, etag, modTime) {
+	case response.StatusNotModified:
+		// a 304 carries the validators of the response it stands for
+		h := headers.NewHeaders()
+		SetValidators(h, etag, modTime)
+		w.WriteStatusLine(response.StatusNotModified)
+		w.WriteHeaders(h)
+		return true
+	case response.StatusPreconditionFailed:
+		res := []byte(response.StatusText(response.StatusPreconditionFailed))
+		w.WriteStatusLine(response.StatusPreconditionFailed)
+		w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+		w.WriteBody(res)
+		return true
+	}
+	return false
+}
+
+func headerTime(req *request.Request, name string) (time.Time, bool) {
+	value, ok := req.Headers.Get(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := ParseTime(strings.TrimSpace(value))
+	return t, err == nil
+}
+
+// matchETag reports whether the If-Match or If-None-Match list matches etag,
+// with the strong comparison for If-Match and the weak one otherwise.
+func matchETag(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return etag != ""
+	}
+	if etag == "" || (strong && isWeak(etag)) {
+		return false
+	}
+
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return false
+		}
+		tag, rest, ok := scanETag(list)
+		if !ok {
+			return false
+		}
+		list = rest
+
+		if strong && isWeak(tag) {
+			continue
+		}
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+}
+
+// scanETag returns the entity tag at the start of s and what follows it.
+func scanETag(s string) (string, string, bool) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s) < start+2 || s[start] != '"' {
+		return "", "", false
+	}
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", "", false
+	}
+	end += start + 2
+	return s[:end], s[end:], true
+}
+
+func isWeak(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
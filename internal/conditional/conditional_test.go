@@ -0,0 +1,98 @@
+package conditional
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+func parse(t *testing.T, method string, fields ...string) *request.Request {
+	t.Helper()
+	raw := method + " /asset HTTP/1.1\r\nHost: localhost\r\n"
+	for _, field := range fields {
+		raw += field + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestValidators(t *testing.T) {
+	modTime := time.Date(2024, time.March, 5, 10, 30, 15, 500, time.UTC)
+
+	// Test: Entity tags
+	assert.Equal(t, ContentETag([]byte("hello")), ContentETag([]byte("hello")))
+	assert.NotEqual(t, ContentETag([]byte("hello")), ContentETag([]byte("world")))
+	assert.True(t, strings.HasPrefix(ContentETag([]byte("hello")), `"`))
+	assert.NotEqual(t, FileETag(modTime, 10), FileETag(modTime, 11))
+	assert.Equal(t, `W/"abc"`, Weak(`"abc"`))
+	assert.Equal(t, `W/"abc"`, Weak(`W/"abc"`))
+
+	// Test: HTTP dates in every accepted format
+	assert.Equal(t, "Tue, 05 Mar 2024 10:30:15 GMT", FormatTime(modTime))
+	for _, s := range []string{"Tue, 05 Mar 2024 10:30:15 GMT", "Tuesday, 05-Mar-24 10:30:15 GMT", "Tue Mar  5 10:30:15 2024"} {
+		parsed, err := ParseTime(s)
+		require.NoError(t, err, s)
+		assert.True(t, parsed.Equal(modTime.Truncate(time.Second)), s)
+	}
+	_, err := ParseTime("yesterday")
+	assert.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	etag := `"v2"`
+	modTime := time.Date(2024, time.March, 5, 10, 30, 15, 500, time.UTC)
+	before := "Tue, 05 Mar 2024 10:00:00 GMT"
+	same := "Tue, 05 Mar 2024 10:30:15 GMT"
+
+	// Test: No preconditions
+	assert.Equal(t, response.StatusCode(0), Check(parse(t, "GET"), etag, modTime))
+
+	// Test: If-None-Match uses the weak comparison
+	assert.Equal(t, response.StatusNotModified, Check(parse(t, "GET", `If-None-Match: "v1", W/"v2"`), etag, modTime))
+	assert.Equal(t, response.StatusNotModified, Check(parse(t, "HEAD", "If-None-Match: *"), etag, modTime))
+	assert.Equal(t, response.StatusCode(0), Check(parse(t, "GET", `If-None-Match: "v1"`), etag, modTime))
+	assert.Equal(t, response.StatusPreconditionFailed, Check(parse(t, "PUT", `If-None-Match: "v2"`), etag, modTime))
+	assert.Equal(t, response.StatusCode(0), Check(parse(t, "PUT", "If-None-Match: *"), "", time.Time{}))
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	assert.Equal(t, response.StatusCode(0), Check(parse(t, "GET", `If-None-Match: "v1"`, "If-Modified-Since: "+same), etag, modTime))
+
+	// Test: If-Modified-Since ignores sub-second precision
+	assert.Equal(t, response.StatusNotModified, Check(parse(t, "GET", "If-Modified-Since: "+same), etag, modTime))
+	assert.Equal(t, response.StatusCode(0), Check(parse(t, "GET", "If-Modified-Since: "+before), etag, modTime))
+	assert.Equal(t, response.StatusCode(0), Check(parse(t, "POST", "If-Modified-Since: "+same), etag, modTime))
+	assert.Equal(t, response.StatusCode(0), Check(parse(t, "GET", "If-Modified-Since: garbage"), etag, modTime))
+
+	// Test: If-Match uses the strong comparison
+	assert.Equal(t, response.StatusCode(0), Check(parse(t, "PUT", `If-Match: "v1", "v2"`), etag, modTime))
+	assert.Equal(t, response.StatusPreconditionFailed, Check(parse(t, "PUT", `If-Match: W/"v2"`), etag, modTime))
+	assert.Equal(t, response.StatusPreconditionFailed, Check(parse(t, "PUT", "If-Match: *"), "", time.Time{}))
+
+	// Test: If-Unmodified-Since
+	assert.Equal(t, response.StatusCode(0), Check(parse(t, "PUT", "If-Unmodified-Since: "+same), etag, modTime))
+	assert.Equal(t, response.StatusPreconditionFailed, Check(parse(t, "PUT", "If-Unmodified-Since: "+before), etag, modTime))
+
+	// Test: If-Range with an entity tag or a date
+	assert.True(t, RangeApplies(parse(t, "GET"), etag, modTime))
+	assert.True(t, RangeApplies(parse(t, "GET", `If-Range: "v2"`), etag, modTime))
+	assert.False(t, RangeApplies(parse(t, "GET", `If-Range: "v1"`), etag, modTime))
+	assert.False(t, RangeApplies(parse(t, "GET", `If-Range: W/"v2"`), Weak(etag), modTime))
+	assert.True(t, RangeApplies(parse(t, "GET", "If-Range: "+same), etag, modTime))
+	assert.False(t, RangeApplies(parse(t, "GET", "If-Range: "+before), etag, modTime))
+
+	// Test: Serve writes a 304 with the validators
+	var buf bytes.Buffer
+	assert.True(t, Serve(response.NewWriter(&buf), parse(t, "GET", "If-None-Match: "+etag), etag, modTime))
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\netag: \"v2\"\r\nlast-modified: "+same+"\r\nconnection: close\r\n\r\n", buf.String())
+	buf.Reset()
+	assert.False(t, Serve(response.NewWriter(&buf), parse(t, "GET"), etag, modTime))
+	assert.Empty(t, buf.String())
+}
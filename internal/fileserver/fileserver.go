@@ -0,0 +1,340 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/conditional"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// indexPage is served in place of a directory listing when present.
+const indexPage = "index.html"
+
+// FileServer serves the files under a directory. Request paths are resolved
+// inside the directory only: ".." segments and symlinks cannot reach files
+// outside of it.
+type FileServer struct {
+	root *os.Root
+	// ListDirectories renders an HTML listing of directories that have no
+	// index.html. Without it, such directories are not found.
+	ListDirectories bool
+}
+
+// New returns a FileServer rooted at dir.
+func New(dir string) (*FileServer, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &FileServer{root: root}, nil
+}
+
+// Close releases the directory the server is rooted at.
+func (fsrv *FileServer) Close() error {
+	return fsrv.root.Close()
+}
+
+// Serve is the server.Handler serving the file named by the request path.
+func (fsrv *FileServer) Serve(w *response.Writer, req *request.Request) {
+	if !allowedMethod(w, req) {
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+req.Target.Path), "/")
+	if name == "" {
+		name = "."
+	}
+
+	f, err := fsrv.root.Open(name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeStatus(w, response.StatusInternal)
+		return
+	}
+
+	if !info.IsDir() {
+		serveContent(w, req, f, info)
+		return
+	}
+
+	// relative links in a directory page need the trailing slash. The
+	// redirect is relative too, since a prefix may have been stripped from
+	// the path the client asked for.
+	if !strings.HasSuffix(req.Target.Path, "/") {
+		target := path.Base(req.Target.RawPath) + "/"
+		if strings.Contains(target, ":") {
+			// keep a name such as "a:b" from being taken for a scheme
+			target = "./" + target
+		}
+		if req.Target.RawQuery != "" {
+			target += "?" + req.Target.RawQuery
+		}
+		redirect(w, target)
+		return
+	}
+
+	index, err := fsrv.root.Open(path.Join(name, indexPage))
+	if err == nil {
+		defer index.Close()
+		if info, err := index.Stat(); err == nil && !info.IsDir() {
+			serveContent(w, req, index, info)
+			return
+		}
+	}
+
+	if !fsrv.ListDirectories {
+		writeStatus(w, response.StatusNotFound)
+		return
+	}
+	serveListing(w, f, req.Target.Path)
+}
+
+// ServeFile serves the file at name, which is not restricted to any
+// directory and so must not come from the request.
+func ServeFile(w *response.Writer, req *request.Request, name string) {
+	if !allowedMethod(w, req) {
+		return
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeStatus(w, response.StatusInternal)
+		return
+	}
+	if info.IsDir() {
+		writeStatus(w, response.StatusNotFound)
+		return
+	}
+
+	serveContent(w, req, f, info)
+}
+
+// serveContent streams the content of f, with the content type looked up from
+// its name or sniffed from its first bytes. Conditional requests are answered
+// with a 304 or 412 when their preconditions call for it, and a GET with a
+// Range header gets only the parts it asks for.
+func serveContent(w *response.Writer, req *request.Request, f *os.File, info fs.FileInfo) {
+	size := info.Size()
+	modTime := info.ModTime()
+	etag := conditional.FileETag(modTime, size)
+	if conditional.Serve(w, req, etag, modTime) {
+		return
+	}
+
+	contentType, err := contentTypeOf(f, info.Name())
+	if err != nil {
+		writeStatus(w, response.StatusInternal)
+		return
+	}
+	v := validators{etag: etag, modTime: modTime}
+
+	rangeHeader, hasRange := req.Headers.Get("range")
+	if hasRange && req.RequestLine.Method == "GET" && conditional.RangeApplies(req, etag, modTime) {
+		ranges, err := parseRange(rangeHeader, size)
+		switch {
+		case errors.Is(err, errNoOverlap):
+			res := []byte(response.StatusText(response.StatusRangeNotSatisfiable))
+			h := response.GetDefaultHeaders(len(res))
+			h.Set("content-range", fmt.Sprintf("bytes */%d", size))
+			w.WriteStatusLine(response.StatusRangeNotSatisfiable)
+			w.WriteHeaders(h)
+			w.WriteBody(res)
+			return
+		case err != nil:
+			// an invalid Range header is ignored
+		case len(ranges) == 1:
+			serveRange(w, f, contentType, v, ranges[0], size)
+			return
+		case sumLength(ranges) <= size:
+			// overlapping ranges adding up to more than the whole content
+			// are not worth answering in parts
+			serveRanges(w, f, contentType, v, ranges, size)
+			return
+		}
+	}
+
+	h := response.GetDefaultHeaders(int(size))
+	h.Set("content-type", contentType)
+	h.Set("accept-ranges", "bytes")
+	v.set(h)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+
+	if _, err := io.Copy(w, f); err != nil {
+		// the status line is out, the client has to see a truncated body
+		w.Abort()
+	}
+}
+
+// serveRange answers with the single range r of f.
+func serveRange(w *response.Writer, f *os.File, contentType string, v validators, r byteRange, size int64) {
+	h := response.GetDefaultHeaders(int(r.length))
+	h.Set("content-type", contentType)
+	h.Set("accept-ranges", "bytes")
+	h.Set("content-range", r.contentRange(size))
+	v.set(h)
+	w.WriteStatusLine(response.StatusPartialContent)
+	w.WriteHeaders(h)
+
+	if _, err := io.Copy(w, io.NewSectionReader(f, r.start, r.length)); err != nil {
+		w.Abort()
+	}
+}
+
+// serveRanges answers with the ranges of f as the parts of a
+// multipart/byteranges body.
+func serveRanges(w *response.Writer, f *os.File, contentType string, v validators, ranges []byteRange, size int64) {
+	boundary := newBoundary()
+
+	length := int64(len(multipartEnd(boundary)))
+	for _, r := range ranges {
+		length += int64(len(multipartPart(boundary, contentType, r, size))) + r.length
+	}
+
+	h := response.GetDefaultHeaders(int(length))
+	h.Set("content-type", "multipart/byteranges; boundary="+boundary)
+	h.Set("accept-ranges", "bytes")
+	v.set(h)
+	w.WriteStatusLine(response.StatusPartialContent)
+	w.WriteHeaders(h)
+
+	for _, r := range ranges {
+		if _, err := io.WriteString(w, multipartPart(boundary, contentType, r, size)); err != nil {
+			w.Abort()
+			return
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(f, r.start, r.length)); err != nil {
+			w.Abort()
+			return
+		}
+	}
+	if _, err := io.WriteString(w, multipartEnd(boundary)); err != nil {
+		w.Abort()
+	}
+}
+
+// validators are the ETag and Last-Modified of a file.
+type validators struct {
+	etag    string
+	modTime time.Time
+}
+
+func (v validators) set(h *headers.Headers) {
+	conditional.SetValidators(h, v.etag, v.modTime)
+}
+
+func sumLength(ranges []byteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r.length
+	}
+	return n
+}
+
+func serveListing(w *response.Writer, dir *os.File, dirPath string) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		writeStatus(w, response.StatusInternal)
+		return
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	var b strings.Builder
+	title := html.EscapeString(dirPath)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	if dirPath != "/" {
+		b.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		link := (&url.URL{Path: name}).EscapedPath()
+		if strings.Contains(name, ":") {
+			// keep a name such as "a:b" from being taken for a scheme
+			link = "./" + link
+		}
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(link), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+
+	res := []byte(b.String())
+	h := response.GetDefaultHeaders(len(res))
+	h.Set("content-type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	w.WriteBody(res)
+}
+
+// allowedMethod answers requests other than GET and HEAD with a 405 and
+// reports whether the request can be served.
+func allowedMethod(w *response.Writer, req *request.Request) bool {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD":
+		return true
+	}
+
+	res := []byte(response.StatusText(response.StatusMethodNotAllowed))
+	h := response.GetDefaultHeaders(len(res))
+	h.Set("allow", "GET, HEAD")
+	w.WriteStatusLine(response.StatusMethodNotAllowed)
+	w.WriteHeaders(h)
+	w.WriteBody(res)
+	return false
+}
+
+func redirect(w *response.Writer, location string) {
+	res := []byte(response.StatusText(response.StatusMovedPermanently))
+	h := response.GetDefaultHeaders(len(res))
+	h.Set("location", location)
+	w.WriteStatusLine(response.StatusMovedPermanently)
+	w.WriteHeaders(h)
+	w.WriteBody(res)
+}
+
+// writeOpenError answers with the status matching an error opening a file.
+// Anything but a permission error, including a path escaping the root, is
+// reported as not found so that nothing is revealed about what lies outside
+// the root.
+func writeOpenError(w *response.Writer, err error) {
+	if errors.Is(err, fs.ErrPermission) {
+		writeStatus(w, response.StatusForbidden)
+		return
+	}
+	writeStatus(w, response.StatusNotFound)
+}
+
+func writeStatus(w *response.Writer, statusCode response.StatusCode) {
+	res := []byte(response.StatusText(statusCode))
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+	w.WriteBody(res)
+}
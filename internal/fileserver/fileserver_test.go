@@ -0,0 +1,229 @@
+package fileserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/router"
+)
+
+func serve(t *testing.T, handler func(w *response.Writer, req *request.Request), raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetHead(req.RequestLine.Method == "HEAD")
+	handler(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func TestFileServer(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello world"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "image"), []byte("\x89PNG\r\n\x1a\n\x00\x00"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "site"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "site", "index.html"), []byte("<p>home</p>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "docs"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "a:b"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "a b.txt"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "<b>.txt"), []byte("b"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "docs", "sub"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "escape")))
+
+	fsrv, err := New(dir)
+	require.NoError(t, err)
+	defer fsrv.Close()
+
+	// Test: File is served with the content type of its extension
+	res := serve(t, fsrv.Serve, "GET /hello.txt HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, res, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, res, "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\nhello world"))
+
+	// Test: Content type of a file without extension is sniffed
+	res = serve(t, fsrv.Serve, "GET /image HTTP/1.1\r\n\r\n")
+	assert.Contains(t, res, "content-type: image/png\r\n")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n\x89PNG\r\n\x1a\n\x00\x00"))
+
+	// Test: HEAD gets the headers without the body
+	res = serve(t, fsrv.Serve, "HEAD /hello.txt HTTP/1.1\r\n\r\n")
+	assert.Contains(t, res, "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n"))
+
+	// Test: Directory serves its index.html
+	res = serve(t, fsrv.Serve, "GET /site/ HTTP/1.1\r\n\r\n")
+	assert.Contains(t, res, "content-type: text/html; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(res, "<p>home</p>"))
+
+	// Test: Directory without trailing slash is redirected
+	res = serve(t, fsrv.Serve, "GET /site?x=1 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, res, "location: site/?x=1\r\n")
+
+	// Test: Redirect to a directory with a colon is not taken for a scheme
+	res = serve(t, fsrv.Serve, "GET /a:b HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, res, "location: ./a:b/\r\n")
+
+	// Test: Redirect is relative to the path the client asked for when mounted
+	rt := router.New()
+	rt.Mount("/static", fsrv.Serve)
+	res = serve(t, rt.Serve, "GET /static/site HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, res, "location: site/\r\n")
+	res = serve(t, rt.Serve, "GET /static/site/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "<p>home</p>"))
+
+	// Test: Directory without index.html is not found unless listings are on
+	res = serve(t, fsrv.Serve, "GET /docs/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+
+	fsrv.ListDirectories = true
+	res = serve(t, fsrv.Serve, "GET /docs/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, res, `<a href="../">../</a>`)
+	assert.Contains(t, res, `<a href="a%20b.txt">a b.txt</a>`)
+	assert.Contains(t, res, `<a href="%3Cb%3E.txt">&lt;b&gt;.txt</a>`)
+	assert.Contains(t, res, `<a href="sub/">sub/</a>`)
+
+	// Test: Paths cannot leave the root
+	res = serve(t, fsrv.Serve, "GET /../../../etc/passwd HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	res = serve(t, fsrv.Serve, "GET /%2e%2e/secret HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	res = serve(t, fsrv.Serve, "GET /escape HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	assert.NotContains(t, res, "secret")
+
+	// Test: Methods other than GET and HEAD are rejected
+	res = serve(t, fsrv.Serve, "DELETE /hello.txt HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, res, "allow: GET, HEAD\r\n")
+
+	// Test: ServeFile serves a single file
+	res = serve(t, func(w *response.Writer, req *request.Request) {
+		ServeFile(w, req, filepath.Join(outside, "secret"))
+	}, "GET /anything HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\nsecret"))
+	assert.Contains(t, res, "content-type: text/plain; charset=utf-8\r\n")
+}
+
+func TestFileServerRanges(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "digits.txt"), []byte("0123456789"), 0o644))
+
+	fsrv, err := New(dir)
+	require.NoError(t, err)
+	defer fsrv.Close()
+
+	get := func(rangeHeader string) string {
+		return serve(t, fsrv.Serve, "GET /digits.txt HTTP/1.1\r\nRange: "+rangeHeader+"\r\n\r\n")
+	}
+
+	// Test: Full response advertises range support
+	res := serve(t, fsrv.Serve, "GET /digits.txt HTTP/1.1\r\n\r\n")
+	assert.Contains(t, res, "accept-ranges: bytes\r\n")
+
+	// Test: Single range
+	res = get("bytes=2-5")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, res, "content-range: bytes 2-5/10\r\n")
+	assert.Contains(t, res, "content-length: 4\r\n")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n2345"))
+
+	// Test: Open-ended and suffix ranges
+	res = get("bytes=7-")
+	assert.Contains(t, res, "content-range: bytes 7-9/10\r\n")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n789"))
+	res = get("bytes=-3")
+	assert.Contains(t, res, "content-range: bytes 7-9/10\r\n")
+	res = get("bytes=-20")
+	assert.Contains(t, res, "content-range: bytes 0-9/10\r\n")
+
+	// Test: Last byte past the end is clamped
+	res = get("bytes=8-100")
+	assert.Contains(t, res, "content-range: bytes 8-9/10\r\n")
+
+	// Test: Multiple ranges are sent as multipart/byteranges
+	res = get("bytes=0-1, 5-6")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 206 Partial Content\r\n"))
+	_, body, ok := strings.Cut(res, "\r\n\r\n")
+	require.True(t, ok)
+	_, boundary, ok := strings.Cut(res, "content-type: multipart/byteranges; boundary=")
+	require.True(t, ok)
+	boundary, _, _ = strings.Cut(boundary, "\r\n")
+	assert.Equal(t, "\r\n--"+boundary+"\r\ncontent-type: text/plain; charset=utf-8\r\ncontent-range: bytes 0-1/10\r\n\r\n01"+
+		"\r\n--"+boundary+"\r\ncontent-type: text/plain; charset=utf-8\r\ncontent-range: bytes 5-6/10\r\n\r\n56"+
+		"\r\n--"+boundary+"--\r\n", body)
+	assert.Contains(t, res, "content-length: "+strconv.Itoa(len(body))+"\r\n")
+
+	// Test: Unsatisfiable range is a 416
+	res = get("bytes=10-20")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, res, "content-range: bytes */10\r\n")
+
+	// Test: Unsatisfiable ranges are dropped when others are satisfiable
+	res = get("bytes=20-30, 1-2")
+	assert.Contains(t, res, "content-range: bytes 1-2/10\r\n")
+
+	// Test: Invalid range headers are ignored
+	for _, rangeHeader := range []string{"bytes=5-2", "items=0-1", "bytes=a-b", "bytes=1"} {
+		res = get(rangeHeader)
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"), rangeHeader)
+		assert.True(t, strings.HasSuffix(res, "\r\n\r\n0123456789"), rangeHeader)
+	}
+
+	// Test: Ranges adding up to more than the file get the whole file
+	res = get("bytes=0-9, 0-9")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestFileServerConditional(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "digits.txt")
+	require.NoError(t, os.WriteFile(name, []byte("0123456789"), 0o644))
+	modTime := time.Date(2024, time.March, 5, 10, 30, 15, 0, time.UTC)
+	require.NoError(t, os.Chtimes(name, modTime, modTime))
+
+	fsrv, err := New(dir)
+	require.NoError(t, err)
+	defer fsrv.Close()
+
+	// Test: Responses carry validators
+	res := serve(t, fsrv.Serve, "GET /digits.txt HTTP/1.1\r\n\r\n")
+	assert.Contains(t, res, "last-modified: Tue, 05 Mar 2024 10:30:15 GMT\r\n")
+	_, etag, ok := strings.Cut(res, "etag: ")
+	require.True(t, ok)
+	etag, _, _ = strings.Cut(etag, "\r\n")
+
+	// Test: Matching validators get a 304 without a body
+	res = serve(t, fsrv.Serve, "GET /digits.txt HTTP/1.1\r\nIf-None-Match: "+etag+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n"))
+	res = serve(t, fsrv.Serve, "GET /digits.txt HTTP/1.1\r\nIf-Modified-Since: Tue, 05 Mar 2024 10:30:15 GMT\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: Stale If-Range gets the whole file
+	res = serve(t, fsrv.Serve, "GET /digits.txt HTTP/1.1\r\nRange: bytes=0-1\r\nIf-Range: \"stale\"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	res = serve(t, fsrv.Serve, "GET /digits.txt HTTP/1.1\r\nRange: bytes=0-1\r\nIf-Range: "+etag+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, res, "etag: "+etag+"\r\n")
+}
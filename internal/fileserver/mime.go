@@ -0,0 +1,102 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"path"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file is looked at to guess its content type.
+const sniffLen = 512
+
+// defaultContentType is used for content that is not recognized.
+const defaultContentType = "application/octet-stream"
+
+// signature recognizes a content type by the bytes at the start of a file.
+type signature struct {
+	offset      int
+	magic       []byte
+	contentType string
+	// markup signatures may follow whitespace and ignore case
+	markup bool
+}
+
+// signatures covers the formats most often served without an extension.
+var signatures = []signature{
+	{0, []byte("%PDF-"), "application/pdf", false},
+	{0, []byte("\x89PNG\r\n\x1a\n"), "image/png", false},
+	{0, []byte("\xff\xd8\xff"), "image/jpeg", false},
+	{0, []byte("GIF87a"), "image/gif", false},
+	{0, []byte("GIF89a"), "image/gif", false},
+	{0, []byte("RIFF"), "audio/wave", false},
+	{0, []byte("\x1a\x45\xdf\xa3"), "video/webm", false},
+	{0, []byte("OggS"), "application/ogg", false},
+	{0, []byte("PK\x03\x04"), "application/zip", false},
+	{0, []byte("\x1f\x8b\x08"), "application/x-gzip", false},
+	{4, []byte("ftyp"), "video/mp4", false},
+	{0, []byte("<!DOCTYPE HTML"), "text/html; charset=utf-8", true},
+	{0, []byte("<HTML"), "text/html; charset=utf-8", true},
+	{0, []byte("<?xml"), "text/xml; charset=utf-8", true},
+}
+
+// contentTypeOf returns the content type of the file f named name, from its
+// extension if it has a known one, or else sniffed from its first bytes. f is
+// left positioned at its start.
+func contentTypeOf(f io.ReadSeeker, name string) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return sniff(buf[:n]), nil
+}
+
+// sniff guesses the content type of data, the start of a file.
+func sniff(data []byte) string {
+	trimmed := bytes.TrimLeft(data, "\t\n\r ")
+	for _, sig := range signatures {
+		if sig.markup {
+			if len(trimmed) >= len(sig.magic) && bytes.EqualFold(trimmed[:len(sig.magic)], sig.magic) {
+				return sig.contentType
+			}
+			continue
+		}
+		if len(data) >= sig.offset+len(sig.magic) && bytes.Equal(data[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.contentType
+		}
+	}
+
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return defaultContentType
+}
+
+// isText reports whether data looks like UTF-8 text: valid, apart from a rune
+// cut at the end, and free of control characters other than whitespace.
+func isText(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 {
+			return len(data) < utf8.UTFMax && !utf8.FullRune(data)
+		}
+		if r < ' ' && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
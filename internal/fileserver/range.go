@@ -0,0 +1,108 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errNoOverlap is returned when none of the requested ranges overlaps the
+// content, which is answered with a 416.
+var errNoOverlap = errors.New("invalid range: failed to overlap")
+
+// byteRange is a part of the content, from start for length bytes.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header value against content of the given size.
+// Ranges that start past the end of the content are dropped; if none is
+// left, errNoOverlap is returned. Any other error means the header is invalid
+// and must be ignored.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(s, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, errors.New("invalid range: unsupported unit")
+	}
+
+	var ranges []byteRange
+	noOverlap := false
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errors.New("invalid range: missing dash")
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// a suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("invalid range: bad suffix length")
+			}
+			if n == 0 || size == 0 {
+				noOverlap = true
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errors.New("invalid range: bad first byte")
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errors.New("invalid range: bad last byte")
+				}
+			}
+			if start >= size {
+				noOverlap = true
+				continue
+			}
+			end = min(end, size-1)
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		if noOverlap {
+			return nil, errNoOverlap
+		}
+		return nil, errors.New("invalid range: no range")
+	}
+	return ranges, nil
+}
+
+// multipartPart is the header introducing one range of a
+// multipart/byteranges body.
+func multipartPart(boundary, contentType string, r byteRange, size int64) string {
+	return fmt.Sprintf("\r\n--%s\r\ncontent-type: %s\r\ncontent-range: %s\r\n\r\n",
+		boundary, contentType, r.contentRange(size))
+}
+
+// multipartEnd closes a multipart/byteranges body.
+func multipartEnd(boundary string) string {
+	return "\r\n--" + boundary + "--\r\n"
+}
+
+func newBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -3,18 +3,39 @@ package headers
 import (
 	"bytes"
 	"errors"
+	"iter"
+	"slices"
 	"strings"
 )
 
 const allowedCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789~#$%^'*+-.^_`|~"
 
-type Headers map[string]string
+// Headers holds header fields in the order they were added. Field names are
+// matched case-insensitively but keep the casing they were added with, and a
+// field can have several values, each kept as its own line.
+type Headers struct {
+	fields []field
+}
+
+type field struct {
+	name  string
+	value string
+}
 
-func NewHeaders() Headers {
-	return Headers{}
+// NewHeaders returns headers holding the given name and value pairs, in
+// order. It panics if pairs has an odd length.
+func NewHeaders(pairs ...string) *Headers {
+	if len(pairs)%2 != 0 {
+		panic("headers: odd number of name and value arguments")
+	}
+	h := &Headers{}
+	for i := 0; i < len(pairs); i += 2 {
+		h.Add(pairs[i], pairs[i+1])
+	}
+	return h
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 	n = 0
 	done = false
 	err = nil
@@ -52,23 +73,108 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	value := strings.Join(parts[1:], ":")
 	value = strings.TrimPrefix(value, " ")
 
-	if value[0] == ' ' {
+	if len(value) > 0 && value[0] == ' ' {
 		return 0, false, errors.New("only 1 OWS allowed")
 	}
 
-	key := strings.ToLower(string(parts[0]))
 	value = strings.Trim(value, " ")
 
-	if v, ok := h[key]; ok {
-		h[key] = v + ", " + value
-	} else {
-		h[key] = value
+	// a bare CR or LF could pass for a line break further down the line
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return 0, false, errors.New("invalid character in header value")
 	}
 
+	h.Add(parts[0], value)
+
 	return len(data[:newLineIdx]) + 2, false, nil
 }
 
-func (h Headers) Get(key string) (string, bool) {
-	value, ok := h[strings.ToLower(key)]
-	return value, ok
+// Add appends a value for name, after any it already has.
+func (h *Headers) Add(name, value string) {
+	h.fields = append(h.fields, field{name: name, value: value})
+}
+
+// Set replaces every value of name with value. The field keeps the position
+// of its first occurrence.
+func (h *Headers) Set(name, value string) {
+	i := slices.IndexFunc(h.fields, func(f field) bool {
+		return strings.EqualFold(f.name, name)
+	})
+	if i == -1 {
+		h.Add(name, value)
+		return
+	}
+
+	h.fields[i] = field{name: name, value: value}
+	rest := slices.DeleteFunc(h.fields[i+1:], func(f field) bool {
+		return strings.EqualFold(f.name, name)
+	})
+	h.fields = h.fields[:i+1+len(rest)]
+}
+
+// Get returns the values of name combined into one, separated by ", ", and
+// whether the field is present at all.
+func (h *Headers) Get(name string) (string, bool) {
+	values := h.Values(name)
+	if values == nil {
+		return "", false
+	}
+	return strings.Join(values, ", "), true
+}
+
+// Values returns every value of name in the order they were added, or nil if
+// the field is not present.
+func (h *Headers) Values(name string) []string {
+	if h == nil {
+		return nil
+	}
+
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, name) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// Del removes every value of name.
+func (h *Headers) Del(name string) {
+	if h == nil {
+		return
+	}
+	h.fields = slices.DeleteFunc(h.fields, func(f field) bool {
+		return strings.EqualFold(f.name, name)
+	})
+}
+
+// Len returns the number of field lines.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// All iterates over the field lines in order, with names in the casing they
+// were added with.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if h == nil {
+			return
+		}
+		for _, f := range h.fields {
+			if !yield(f.name, f.value) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a copy of h that can be changed independently.
+func (h *Headers) Clone() *Headers {
+	if h == nil {
+		return NewHeaders()
+	}
+	return &Headers{fields: slices.Clone(h.fields)}
 }
@@ -14,7 +14,7 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -32,7 +32,7 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 31, n)
 	assert.False(t, done)
 
@@ -42,14 +42,14 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 31, n)
 	assert.False(t, done)
 
 	n, done, err = headers.Parse(data[n:])
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "test-agent", headers["user-agent"])
+	assert.Equal(t, []string{"test-agent"}, headers.Values("user-agent"))
 	assert.Equal(t, 32, n)
 	assert.False(t, done)
 
@@ -91,16 +91,25 @@ func TestHeadersParse(t *testing.T) {
 	data = []byte("Authorization: Bearer: token:123\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "Bearer: token:123", headers["authorization"])
+	assert.Equal(t, []string{"Bearer: token:123"}, headers.Values("authorization"))
 	assert.Equal(t, 34, n)
 	assert.False(t, done)
 
+	// Test: Header with an empty value
+	headers = NewHeaders()
+	data = []byte("X-Empty:\r\n")
+	n, done, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, []string{""}, headers.Values("x-empty"))
+	assert.Equal(t, 10, n)
+	assert.False(t, done)
+
 	// Test: Header with only one space after colon
 	headers = NewHeaders()
 	data = []byte("Content-Type: application/json\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "application/json", headers["content-type"])
+	assert.Equal(t, []string{"application/json"}, headers.Values("content-type"))
 	assert.Equal(t, 32, n)
 	assert.False(t, done)
 
@@ -109,7 +118,7 @@ func TestHeadersParse(t *testing.T) {
 	data = []byte("Accept:text/html\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "text/html", headers["accept"])
+	assert.Equal(t, []string{"text/html"}, headers.Values("accept"))
 	assert.Equal(t, 18, n)
 	assert.False(t, done)
 
@@ -122,13 +131,23 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
+	// Test: CR, LF and NUL are refused in values
+	for _, line := range []string{"X: a\rb\r\n", "X: a\nb\r\n", "X: a\x00b\r\n", "X: \r\r\n"} {
+		headers = NewHeaders()
+		n, done, err = headers.Parse([]byte(line))
+		require.Error(t, err, "%q", line)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+		assert.Equal(t, 0, headers.Len())
+	}
+
 	// Test: multiple same name header
 	headers = NewHeaders()
-	headers["host"] = "localhost:42069"
+	headers.Set("Host", "localhost:42069")
 	data = []byte("Host: localhost:42070\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "localhost:42069, localhost:42070", headers["host"])
+	assert.Equal(t, []string{"localhost:42069", "localhost:42070"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -137,13 +156,13 @@ func TestHeadersParse(t *testing.T) {
 	data = []byte("Host: localhost:42069\r\nHost: localhost:42070\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
 	prevN, done, err := headers.Parse(data[n:])
 	require.NoError(t, err)
-	assert.Equal(t, "localhost:42069, localhost:42070", headers["host"])
+	assert.Equal(t, []string{"localhost:42069", "localhost:42070"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 	// test done after it
@@ -152,3 +171,64 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 2, n)
 	assert.True(t, done)
 }
+
+func TestHeadersMultipleValues(t *testing.T) {
+	// Test: Values are kept apart, in order, with their original casing
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1; Path=/\r\nContent-Type: text/html\r\nset-cookie: b=2\r\n\r\n")
+	for {
+		n, done, err := headers.Parse(data)
+		require.NoError(t, err)
+		data = data[n:]
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a=1; Path=/", "b=2"}, headers.Values("SET-COOKIE"))
+	assert.Equal(t, 3, headers.Len())
+
+	var lines []string
+	for name, value := range headers.All() {
+		lines = append(lines, name+": "+value)
+	}
+	assert.Equal(t, []string{"Set-Cookie: a=1; Path=/", "Content-Type: text/html", "set-cookie: b=2"}, lines)
+
+	// Test: Get combines the values
+	value, ok := headers.Get("set-cookie")
+	assert.True(t, ok)
+	assert.Equal(t, "a=1; Path=/, b=2", value)
+	_, ok = headers.Get("missing")
+	assert.False(t, ok)
+
+	// Test: Set replaces every value in place of the first one
+	headers.Set("set-cookie", "c=3")
+	lines = nil
+	for name, value := range headers.All() {
+		lines = append(lines, name+": "+value)
+	}
+	assert.Equal(t, []string{"set-cookie: c=3", "Content-Type: text/html"}, lines)
+
+	// Test: Add appends and Del removes every value
+	headers.Add("Vary", "Accept")
+	headers.Add("Vary", "Accept-Encoding")
+	assert.Equal(t, []string{"Accept", "Accept-Encoding"}, headers.Values("vary"))
+	headers.Del("VARY")
+	assert.Nil(t, headers.Values("vary"))
+	assert.Equal(t, 2, headers.Len())
+
+	// Test: Clone is independent
+	clone := headers.Clone()
+	clone.Set("Content-Type", "text/plain")
+	assert.Equal(t, []string{"text/html"}, headers.Values("content-type"))
+
+	// Test: NewHeaders adds its pairs in order
+	headers = NewHeaders("Vary", "Accept", "Host", "localhost", "vary", "Cookie")
+	assert.Equal(t, []string{"Accept", "Cookie"}, headers.Values("vary"))
+	assert.Equal(t, 3, headers.Len())
+	assert.Panics(t, func() { NewHeaders("Vary") })
+
+	// Test: Nil headers read as empty
+	var empty *Headers
+	assert.Zero(t, empty.Len())
+	assert.Nil(t, empty.Values("host"))
+}
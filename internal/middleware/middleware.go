@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// RequestIDHeader is the header RequestID reads and sets.
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength bounds request IDs accepted from clients so they can be
+// logged safely.
+const maxRequestIDLength = 128
+
+// Logger logs the method, target, status and duration of every request. A nil
+// logger uses the standard logger.
+func Logger(logger *log.Logger) server.Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+
+			logger.Printf("%s %s %d %s",
+				req.RequestLine.Method, req.RequestLine.RequestTarget, w.StatusCode(), time.Since(start))
+		}
+	}
+}
+
+// Recoverer turns a panic in the handler into a 500 response. If the handler
+// already started its response, the connection is closed instead so the
+// client does not mistake the truncated response for a complete one. A nil
+// logger uses the standard logger.
+func Recoverer(logger *log.Logger) server.Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				logger.Printf("panic serving %s %s: %v\n%s",
+					req.RequestLine.Method, req.RequestLine.RequestTarget, rec, debug.Stack())
+
+				if w.StatusCode() != 0 {
+					w.Abort()
+					return
+				}
+
+				res := []byte("Internal Server Error")
+				w.WriteStatusLine(response.StatusInternal)
+				w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+				w.WriteBody(res)
+			}()
+
+			next(w, req)
+		}
+	}
+}
+
+// RequestID makes sure every request carries an X-Request-Id header, keeping
+// the one sent by the client or generating a new one, and echoes it on the
+// response.
+func RequestID(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		id, ok := req.Headers.Get(RequestIDHeader)
+		if !ok || !validRequestID(id) {
+			id = newRequestID()
+			req.Headers.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		next(w, req)
+	}
+}
+
+// GetRequestID returns the ID assigned to req by RequestID.
+func GetRequestID(req *request.Request) string {
+	id, _ := req.Headers.Get(RequestIDHeader)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Timing reports how long the handler took to serve each request, along with
+// the status it responded with.
+func Timing(report func(req *request.Request, statusCode response.StatusCode, d time.Duration)) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			report(req, w.StatusCode(), time.Since(start))
+		}
+	}
+}
+
+// Compress compresses response bodies with gzip or deflate, whichever the
+// client prefers according to its Accept-Encoding header. The response
+// Writer leaves alone bodies that are not worth compressing.
+func Compress(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		acceptEncoding, _ := req.Headers.Get("accept-encoding")
+		if encoding := negotiateEncoding(acceptEncoding); encoding != "" {
+			w.EnableCompression(encoding)
+		}
+		next(w, req)
+	}
+}
+
+// negotiateEncoding returns the supported content-coding with the highest
+// q-value in an Accept-Encoding header, preferring gzip on ties, or "" if
+// none is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	qvalues := map[string]float64{}
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		qvalues[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := qvalues[coding]
+		if !ok {
+			q = qvalues["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+func serve(t *testing.T, handler server.Handler, raw string) (*response.Writer, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	handler(w, req)
+	return w, buf.String()
+}
+
+func ok(w *response.Writer, req *request.Request) {
+	res := []byte(GetRequestID(req))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+	w.WriteBody(res)
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name)
+				next(w, req)
+			}
+		}
+	}
+
+	handler := server.Chain(ok, mark("first"), mark("second"))
+	serve(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, []string{"first", "second"}, order)
+}
+
+func TestRecoverer(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+
+	// Test: Panic before the response started is a 500
+	handler := Recoverer(logger)(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	})
+	w, res := serve(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Equal(t, response.StatusInternal, w.StatusCode())
+
+	// Test: Panic mid-response closes the connection
+	handler = Recoverer(logger)(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		panic("boom")
+	})
+	w, res = serve(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", res)
+	assert.False(t, w.KeepAlive())
+	assert.ErrorIs(t, w.Finish(), response.ErrAborted)
+}
+
+func TestRequestID(t *testing.T) {
+	// Test: ID is generated and echoed on the response
+	_, res := serve(t, RequestID(ok), "GET / HTTP/1.1\r\n\r\n")
+	head, body, _ := strings.Cut(res, "\r\n\r\n")
+	assert.Len(t, body, 32)
+	assert.Contains(t, head, "x-request-id: "+body+"\r\n")
+
+	// Test: ID sent by the client is kept
+	_, res = serve(t, RequestID(ok), "GET / HTTP/1.1\r\nX-Request-Id: abc-123\r\n\r\n")
+	assert.Contains(t, res, "x-request-id: abc-123\r\n")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\nabc-123"))
+}
+
+func TestTimingAndLogger(t *testing.T) {
+	var status response.StatusCode
+	var took time.Duration
+	var logs bytes.Buffer
+
+	handler := server.Chain(ok,
+		Logger(log.New(&logs, "", 0)),
+		Timing(func(req *request.Request, statusCode response.StatusCode, d time.Duration) {
+			status, took = statusCode, d
+		}),
+	)
+	serve(t, handler, "GET /coffee HTTP/1.1\r\n\r\n")
+
+	assert.Equal(t, response.StatusOK, status)
+	assert.Positive(t, took)
+	assert.True(t, strings.HasPrefix(logs.String(), "GET /coffee 200 "))
+}
+
+func TestCompress(t *testing.T) {
+	// Test: Encoding is negotiated from q-values
+	for acceptEncoding, expected := range map[string]string{
+		"":                          "",
+		"gzip":                      "gzip",
+		"deflate":                   "deflate",
+		"deflate, gzip":             "gzip",
+		"gzip;q=0.5, deflate":       "deflate",
+		"GZIP;Q=0.8, deflate;q=0.3": "gzip",
+		"gzip;q=0, deflate;q=0":     "",
+		"*":                         "gzip",
+		"*;q=0.5, gzip;q=0":         "deflate",
+		"br, identity":              "",
+		"x-gzip":                    "gzip",
+		"gzip;q=2":                  "",
+	} {
+		assert.Equal(t, expected, negotiateEncoding(acceptEncoding), acceptEncoding)
+	}
+
+	payload := strings.Repeat("compress me ", 100)
+	handler := Compress(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(payload)))
+		w.WriteBody([]byte(payload))
+	})
+
+	// Test: Response is compressed for a client accepting gzip
+	w, res := serve(t, handler, "GET / HTTP/1.1\r\nAccept-Encoding: gzip, deflate\r\n\r\n")
+	require.NoError(t, w.Finish())
+	assert.Contains(t, res, "content-encoding: gzip\r\n")
+	assert.Contains(t, res, "vary: accept-encoding\r\n")
+	assert.NotContains(t, res, payload)
+
+	// Test: Response is left alone otherwise
+	_, res = serve(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.NotContains(t, res, "content-encoding")
+	assert.True(t, strings.HasSuffix(res, payload))
+}
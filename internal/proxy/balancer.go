@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"net"
+	"sync/atomic"
+
+	"httpfromtcp/internal/request"
+)
+
+// Balancer picks the upstream a request is forwarded to. Implementations must
+// be safe for concurrent use.
+type Balancer interface {
+	// Pick returns one of upstreams, which holds the upstreams currently
+	// available and is never empty.
+	Pick(upstreams []*Upstream, req *request.Request) *Upstream
+}
+
+// RoundRobin returns a balancer cycling through the upstreams in turn.
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (b *roundRobin) Pick(upstreams []*Upstream, req *request.Request) *Upstream {
+	n := b.next.Add(1) - 1
+	return upstreams[n%uint64(len(upstreams))]
+}
+
+// LeastConnections returns a balancer picking the upstream with the fewest
+// requests in flight, the first one listed on ties.
+func LeastConnections() Balancer {
+	return leastConnections{}
+}
+
+type leastConnections struct{}
+
+func (leastConnections) Pick(upstreams []*Upstream, req *request.Request) *Upstream {
+	best := upstreams[0]
+	for _, up := range upstreams[1:] {
+		if up.Active() < best.Active() {
+			best = up
+		}
+	}
+	return best
+}
+
+// ConsistentHash returns a balancer sending the requests with the same key to
+// the same upstream. It uses rendezvous hashing, so an upstream going down
+// only moves the keys it was serving. A nil key hashes on the client IP.
+func ConsistentHash(key func(req *request.Request) string) Balancer {
+	if key == nil {
+		key = clientIP
+	}
+	return consistentHash{key: key}
+}
+
+type consistentHash struct {
+	key func(req *request.Request) string
+}
+
+func (b consistentHash) Pick(upstreams []*Upstream, req *request.Request) *Upstream {
+	key := b.key(req)
+
+	var best *Upstream
+	var bestScore uint64
+	for _, up := range upstreams {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(up.URL.String()))
+		if score := mix(h.Sum64()); best == nil || score > bestScore {
+			best, bestScore = up, score
+		}
+	}
+	return best
+}
+
+// mix spreads the bits of an FNV hash, whose high bits barely change between
+// keys differing only in their last bytes.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// clientIP returns the IP address req was sent from.
+func clientIP(req *request.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
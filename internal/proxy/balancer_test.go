@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+func upstreams(n int) []*Upstream {
+	ups := make([]*Upstream, n)
+	for i := range ups {
+		ups[i] = &Upstream{URL: &url.URL{Scheme: "http", Host: fmt.Sprintf("10.0.0.%d:80", i+1)}}
+	}
+	return ups
+}
+
+func TestBalancers(t *testing.T) {
+	ups := upstreams(3)
+	req := &request.Request{RemoteAddr: "192.0.2.1:5000"}
+
+	// Test: Round-robin cycles through the upstreams
+	rr := RoundRobin()
+	for i := range 6 {
+		assert.Same(t, ups[i%3], rr.Pick(ups, req))
+	}
+
+	// Test: Least-connections picks the least busy upstream, the first on ties
+	lc := LeastConnections()
+	assert.Same(t, ups[0], lc.Pick(ups, req))
+	ups[0].active.Add(2)
+	ups[1].active.Add(1)
+	ups[2].active.Add(1)
+	assert.Same(t, ups[1], lc.Pick(ups, req))
+	ups[1].active.Add(1)
+	assert.Same(t, ups[2], lc.Pick(ups, req))
+
+	// Test: Consistent hashing sticks to an upstream per client IP
+	ch := ConsistentHash(nil)
+	picked := ch.Pick(ups, req)
+	for range 5 {
+		assert.Same(t, picked, ch.Pick(ups, &request.Request{RemoteAddr: "192.0.2.1:6000"}))
+	}
+
+	// Test: Consistent hashing only moves the keys of a removed upstream
+	ch = ConsistentHash(func(req *request.Request) string { return req.Param("key") })
+	before := map[string]*Upstream{}
+	seen := map[*Upstream]int{}
+	for i := range 300 {
+		key := fmt.Sprint(i)
+		up := ch.Pick(ups, &request.Request{Params: map[string]string{"key": key}})
+		before[key] = up
+		seen[up]++
+	}
+	assert.Len(t, seen, 3)
+	for _, up := range ups {
+		assert.Greater(t, seen[up], 50)
+	}
+	for key, up := range before {
+		after := ch.Pick(ups[1:], &request.Request{Params: map[string]string{"key": key}})
+		if up == ups[0] {
+			assert.NotSame(t, ups[0], after)
+		} else {
+			assert.Same(t, up, after)
+		}
+	}
+}
+
+// backend starts a server answering with name, and 503 on /health while down
+// is set.
+func backend(t *testing.T, name string, down *atomic.Bool) *server.Server {
+	t.Helper()
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.Target.Path == "/health" && down.Load() {
+			writeStatus(w, response.StatusServiceUnavailable)
+			return
+		}
+		res := []byte(name)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+		w.WriteBody(res)
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// hits sends n requests through the proxy at front and counts the answers by
+// body.
+func hits(t *testing.T, c *client.Client, front string, n int) map[string]int {
+	t.Helper()
+	got := map[string]int{}
+	for range n {
+		res, body := do(t, c, mustRequest(t, "GET", front+"/", nil))
+		if res.StatusLine.StatusCode != response.StatusOK {
+			body = fmt.Sprint(res.StatusLine.StatusCode)
+		}
+		got[body]++
+	}
+	return got
+}
+
+func TestProxyLoadBalancing(t *testing.T) {
+	var aDown, bDown, cDown atomic.Bool
+	a := backend(t, "a", &aDown)
+	b := backend(t, "b", &bDown)
+	cb := backend(t, "c", &cDown)
+
+	p, err := New("http://"+a.Addr, "http://"+b.Addr, "http://"+cb.Addr)
+	require.NoError(t, err)
+	p.ErrorLog = log.New(io.Discard, "", 0)
+	defer p.Close()
+	front := serve(t, p.Serve)
+	c := client.New()
+	defer c.CloseIdleConnections()
+
+	// Test: Requests are spread round-robin by default
+	assert.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, hits(t, c, front, 6))
+
+	// Test: Health checks take failing upstreams out of rotation
+	p.StartHealthChecks(HealthCheck{Path: "/health", Interval: 10 * time.Millisecond})
+	bDown.Store(true)
+	require.Eventually(t, func() bool { return !p.Upstreams()[1].Available() }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, map[string]int{"a": 2, "c": 2}, hits(t, c, front, 4))
+
+	// Test: And put them back once they recover
+	bDown.Store(false)
+	require.Eventually(t, func() bool { return p.Upstreams()[1].Available() }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 3, len(hits(t, c, front, 6)))
+
+	// Test: No upstream available is a 503
+	aDown.Store(true)
+	bDown.Store(true)
+	cDown.Store(true)
+	require.Eventually(t, func() bool {
+		for _, up := range p.Upstreams() {
+			if up.Available() {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, map[string]int{"503": 2}, hits(t, c, front, 2))
+
+	// Test: Stopped health checks leave the upstreams as they were last seen
+	require.NoError(t, p.Close())
+	aDown.Store(false)
+	time.Sleep(30 * time.Millisecond)
+	assert.False(t, p.Upstreams()[0].Available())
+}
+
+func TestProxyPassiveEjection(t *testing.T) {
+	var down atomic.Bool
+	a := backend(t, "a", &down)
+	b := backend(t, "b", &down)
+	dead := backend(t, "dead", &down)
+	deadAddr := dead.Addr
+	dead.Close()
+
+	p, err := New("http://"+a.Addr, "http://"+deadAddr, "http://"+b.Addr)
+	require.NoError(t, err)
+	p.ErrorLog = log.New(io.Discard, "", 0)
+	p.MaxFails = 2
+	p.FailTimeout = 100 * time.Millisecond
+	p.Balancer = RoundRobin()
+	defer p.Close()
+	front := serve(t, p.Serve)
+	c := client.New()
+	defer c.CloseIdleConnections()
+
+	// Test: Failures below MaxFails keep the upstream in rotation
+	assert.Equal(t, map[string]int{"a": 1, "502": 1, "b": 1}, hits(t, c, front, 3))
+	assert.True(t, p.Upstreams()[1].Available())
+
+	// Test: Reaching MaxFails ejects the upstream for FailTimeout
+	assert.Equal(t, map[string]int{"a": 1, "502": 1, "b": 1}, hits(t, c, front, 3))
+	assert.False(t, p.Upstreams()[1].Available())
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, hits(t, c, front, 4))
+
+	// Test: Ejected upstreams are tried again after FailTimeout
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, p.Upstreams()[1].Available())
+	assert.Equal(t, 1, hits(t, c, front, 3)["502"])
+}
@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// hopHeaders are the fields that only apply to a single connection and are
+// not forwarded in either direction.
+var hopHeaders = []string{
+	"connection",
+	"proxy-connection",
+	"keep-alive",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// Proxy is a reverse proxy: it forwards requests to one of its upstream
+// servers and streams the upstream response back to the client.
+type Proxy struct {
+	upstreams []*Upstream
+	// Client sends the requests upstream.
+	Client *client.Client
+	// Balancer picks the upstream of each request, RoundRobin by default.
+	Balancer Balancer
+	// MaxFails is the number of consecutive requests an upstream may fail to
+	// answer before it is ejected for FailTimeout. Zero never ejects
+	// upstreams.
+	MaxFails    int
+	FailTimeout time.Duration
+	// PreserveHost forwards the Host header of the incoming request instead
+	// of setting it to the upstream host.
+	PreserveHost bool
+	// ErrorLog logs the upstream failures. A nil logger uses the standard
+	// logger.
+	ErrorLog *log.Logger
+	// Trailer, if set, is called for every response with a body before its
+	// headers are sent, which it may change. The Trailer it returns sees the
+	// body as it streams to the client, and its fields are sent as trailers
+	// after the body, which is then always chunked.
+	Trailer func(h *headers.Headers) Trailer
+
+	mu      sync.Mutex
+	checker *healthChecker
+}
+
+// Trailer computes trailer fields from a response body, such as a checksum.
+type Trailer interface {
+	// Write is given the body as it is copied to the client.
+	io.Writer
+	// Trailers returns the fields sent after the body.
+	Trailers() *headers.Headers
+}
+
+// New returns a proxy balancing requests over the upstreams at targets, http
+// or https URLs whose path, if any, prefixes the path of every request
+// forwarded to them.
+func New(targets ...string) (*Proxy, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("proxy: no upstream target")
+	}
+
+	upstreams := make([]*Upstream, 0, len(targets))
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("proxy: target %q must be an absolute http or https URL", target)
+		}
+		upstreams = append(upstreams, &Upstream{URL: u})
+	}
+
+	return &Proxy{
+		upstreams:   upstreams,
+		Client:      client.New(),
+		Balancer:    RoundRobin(),
+		FailTimeout: DefaultFailTimeout,
+	}, nil
+}
+
+// Upstreams returns the upstreams of the proxy, in the order of the targets
+// given to New.
+func (p *Proxy) Upstreams() []*Upstream {
+	return slices.Clone(p.upstreams)
+}
+
+// pick returns the upstream req is forwarded to, or nil if none is
+// available.
+func (p *Proxy) pick(req *request.Request) *Upstream {
+	available := make([]*Upstream, 0, len(p.upstreams))
+	for _, up := range p.upstreams {
+		if up.Available() {
+			available = append(available, up)
+		}
+	}
+	if len(available) == 0 {
+		return nil
+	}
+	return p.Balancer.Pick(available, req)
+}
+
+// Serve is the server.Handler forwarding req upstream.
+func (p *Proxy) Serve(w *response.Writer, req *request.Request) {
+	up := p.pick(req)
+	if up == nil {
+		p.logf("proxy: %s %s: no upstream available", req.RequestLine.Method, req.RequestLine.RequestTarget)
+		writeStatus(w, response.StatusServiceUnavailable)
+		return
+	}
+	up.active.Add(1)
+	defer up.active.Add(-1)
+
+	out, err := p.outgoing(req, up)
+	if err != nil {
+		p.fail(w, req, err)
+		return
+	}
+
+	res, err := p.Client.Do(out)
+	if err != nil {
+		up.failed(p.MaxFails, p.FailTimeout)
+		p.fail(w, req, err)
+		return
+	}
+	up.succeeded()
+	defer res.Body.Close()
+
+	h := res.Headers.Clone()
+	removeHopHeaders(h)
+	code := res.StatusLine.StatusCode
+	noBody := req.RequestLine.Method == "HEAD" || code < 200 || code == response.StatusNoContent || code == response.StatusNotModified
+	var trailer Trailer
+	if p.Trailer != nil && !noBody {
+		trailer = p.Trailer(h)
+	}
+	_, hasLength := h.Get("content-length")
+	chunked := (!hasLength || trailer != nil) && !noBody
+	if chunked {
+		// a body of unknown length is streamed in chunks, which keeps the
+		// client connection alive and carries the trailers
+		h.Del("content-length")
+		h.Set("transfer-encoding", "chunked")
+	}
+
+	if err := w.WriteStatusLineReason(code, res.StatusLine.Reason); err != nil {
+		w.Abort()
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		w.Abort()
+		return
+	}
+
+	var body io.Reader = res.Body
+	if trailer != nil {
+		body = io.TeeReader(res.Body, trailer)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		p.logf("proxy: copying response body for %s: %v", req.RequestLine.RequestTarget, err)
+		w.Abort()
+		return
+	}
+
+	if !chunked {
+		return
+	}
+	trailers := res.Trailers.Clone()
+	removeHopHeaders(trailers)
+	if trailer != nil {
+		for k, v := range trailer.Trailers().All() {
+			trailers.Add(k, v)
+		}
+	}
+	if trailers.Len() > 0 {
+		if _, err := w.WriteChunkedBodyDone(); err == nil {
+			w.WriteTrailers(trailers)
+		}
+	}
+}
+
+// outgoing builds the request forwarding req to up.
+func (p *Proxy) outgoing(req *request.Request, up *Upstream) (*client.Request, error) {
+	u := *up.URL
+	u.Path = joinPath(up.URL.Path, req.Target.Path)
+	u.RawPath = joinPath(up.URL.EscapedPath(), req.Target.RawPath)
+	u.RawQuery = req.Target.RawQuery
+
+	h := req.Headers.Clone()
+	removeHopHeaders(h)
+	// the proxy reads the body itself, which already sent any 100 Continue
+	h.Del("expect")
+
+	host, _ := req.Headers.Get("host")
+	if !p.PreserveHost {
+		h.Set("host", up.URL.Host)
+	}
+	addForwarded(h, req, host)
+
+	out := &client.Request{
+		Method:  req.RequestLine.Method,
+		URL:     &u,
+		Headers: h,
+	}
+
+	contentLength, hasLength := req.Headers.Get("content-length")
+	_, hasEncoding := req.Headers.Get("transfer-encoding")
+	switch {
+	case hasEncoding:
+		out.Body = req.Body
+		out.ContentLength = -1
+	case hasLength:
+		n, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			out.Body = req.Body
+			out.ContentLength = n
+		}
+	}
+
+	return out, nil
+}
+
+// addForwarded records the client, host and protocol of req in the
+// X-Forwarded-* and Forwarded headers, after those of earlier proxies.
+func addForwarded(h *headers.Headers, req *request.Request, host string) {
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	ip := clientIP(req)
+	if ip != "" {
+		if prior, ok := h.Get("x-forwarded-for"); ok {
+			h.Set("x-forwarded-for", prior+", "+ip)
+		} else {
+			h.Set("x-forwarded-for", ip)
+		}
+	}
+	if _, ok := h.Get("x-forwarded-host"); !ok && host != "" {
+		h.Set("x-forwarded-host", host)
+	}
+	if _, ok := h.Get("x-forwarded-proto"); !ok {
+		h.Set("x-forwarded-proto", proto)
+	}
+
+	var elems []string
+	if ip != "" {
+		elems = append(elems, "for="+forwardedNode(ip))
+	}
+	if host != "" {
+		elems = append(elems, "host="+quoteIfNeeded(host))
+	}
+	elems = append(elems, "proto="+proto)
+	h.Add("forwarded", strings.Join(elems, ";"))
+}
+
+// forwardedNode formats an IP address for the Forwarded header, where IPv6
+// addresses are bracketed and quoted.
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+func quoteIfNeeded(s string) string {
+	if strings.ContainsAny(s, `:[]";, `) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// removeHopHeaders deletes the hop-by-hop fields from h, including those
+// named in its Connection header.
+func removeHopHeaders(h *headers.Headers) {
+	for _, value := range h.Values("connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func joinPath(base, path string) string {
+	if base == "" || base == "/" {
+		return path
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// fail answers with a 502 when the upstream could not be reached, or drops
+// the connection if the response had already started.
+func (p *Proxy) fail(w *response.Writer, req *request.Request, err error) {
+	p.logf("proxy: %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+
+	if w.StatusCode() != 0 {
+		w.Abort()
+		return
+	}
+	writeStatus(w, response.StatusBadGateway)
+}
+
+// writeStatus answers with statusCode and its reason phrase as the body.
+func writeStatus(w *response.Writer, statusCode response.StatusCode) {
+	res := []byte(response.StatusText(statusCode))
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+	w.WriteBody(res)
+}
+
+func (p *Proxy) logf(format string, args ...any) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// upstream describes the request it got in its body, one field per line.
+func upstream(w *response.Writer, req *request.Request) {
+	switch req.Target.Path {
+	case "/base/teapot":
+		res := []byte("short and stout")
+		h := response.GetDefaultHeaders(len(res))
+		h.Set("x-custom", "kept")
+		h.Set("connection", "x-hop")
+		h.Set("x-hop", "dropped")
+		w.WriteStatusLineReason(response.StatusAccepted, "Short And Stout")
+		w.WriteHeaders(h)
+		w.WriteBody(res)
+		return
+	case "/base/stream":
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.NewHeaders("transfer-encoding", "chunked", "trailer", "x-sum"))
+		w.WriteChunkedBody([]byte("part one, "))
+		w.WriteChunkedBody([]byte("part two"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.NewHeaders("x-sum", "abc"))
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "target=%s\n", req.RequestLine.RequestTarget)
+	fmt.Fprintf(&b, "method=%s\n", req.RequestLine.Method)
+	fmt.Fprintf(&b, "body=%s\n", body)
+	for k, v := range req.Headers.All() {
+		fmt.Fprintf(&b, "%s=%s\n", strings.ToLower(k), v)
+	}
+	res := []byte(b.String())
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+	w.WriteBody(res)
+}
+
+func serve(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	srv := server.New(handler)
+	require.NoError(t, srv.StartAddr("tcp", "127.0.0.1:0"))
+	t.Cleanup(func() { srv.Close() })
+	return "http://" + srv.Addr
+}
+
+func do(t *testing.T, c *client.Client, req *client.Request) (*response.Response, string) {
+	t.Helper()
+	res, err := c.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestProxy(t *testing.T) {
+	back := serve(t, upstream)
+	p, err := New(back + "/base")
+	require.NoError(t, err)
+	p.ErrorLog = log.New(io.Discard, "", 0)
+	front := serve(t, p.Serve)
+	c := client.New()
+	defer c.CloseIdleConnections()
+
+	// Test: Method, path, query and headers are forwarded, hop-by-hop ones
+	// stripped and forwarding headers added
+	req, err := client.NewRequest("GET", front+"/items/%2F1?q=a%20b", nil)
+	require.NoError(t, err)
+	req.Headers.Set("x-token", "secret")
+	req.Headers.Set("connection", "x-private")
+	req.Headers.Set("x-private", "hop")
+	req.Headers.Set("x-forwarded-for", "10.0.0.1")
+	res, body := do(t, c, req)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Contains(t, body, "target=/base/items/%2F1?q=a%20b\n")
+	assert.Contains(t, body, "method=GET\n")
+	assert.Contains(t, body, "x-token=secret\n")
+	assert.NotContains(t, body, "x-private")
+	assert.NotContains(t, body, "connection=")
+	assert.Contains(t, body, "host="+strings.TrimPrefix(back, "http://")+"\n")
+	assert.Contains(t, body, "x-forwarded-for=10.0.0.1, 127.0.0.1\n")
+	assert.Contains(t, body, "x-forwarded-host="+strings.TrimPrefix(front, "http://")+"\n")
+	assert.Contains(t, body, "x-forwarded-proto=http\n")
+	assert.Contains(t, body, `forwarded=for=127.0.0.1;host="`+strings.TrimPrefix(front, "http://")+`";proto=http`)
+
+	// Test: Status, reason and end-to-end headers come back
+	res, body = do(t, c, mustRequest(t, "GET", front+"/teapot", nil))
+	assert.Equal(t, response.StatusAccepted, res.StatusLine.StatusCode)
+	assert.Equal(t, "Short And Stout", res.StatusLine.Reason)
+	assert.Equal(t, "short and stout", body)
+	assert.Equal(t, []string{"kept"}, res.Headers.Values("x-custom"))
+	assert.Nil(t, res.Headers.Values("x-hop"))
+
+	// Test: Chunked upstream body is streamed with its trailers
+	res, body = do(t, c, mustRequest(t, "GET", front+"/stream", nil))
+	assert.Equal(t, "part one, part two", body)
+	assert.Equal(t, []string{"abc"}, res.Trailers.Values("x-sum"))
+
+	// Test: Trailer hook sees the body and adds its trailers to the upstream
+	// ones, chunking sized bodies
+	p.Trailer = func(h *headers.Headers) Trailer {
+		h.Set("trailer", "x-length")
+		return &lengthTrailer{}
+	}
+	res, body = do(t, c, mustRequest(t, "GET", front+"/stream", nil))
+	assert.Equal(t, "part one, part two", body)
+	assert.Equal(t, []string{"abc"}, res.Trailers.Values("x-sum"))
+	assert.Equal(t, []string{"18"}, res.Trailers.Values("x-length"))
+	res, body = do(t, c, mustRequest(t, "GET", front+"/teapot", nil))
+	assert.Equal(t, "short and stout", body)
+	assert.Nil(t, res.Headers.Values("content-length"))
+	assert.Equal(t, []string{"15"}, res.Trailers.Values("x-length"))
+	res, body = do(t, c, mustRequest(t, "HEAD", front+"/teapot", nil))
+	assert.Empty(t, body)
+	assert.Equal(t, []string{"15"}, res.Headers.Values("content-length"))
+	p.Trailer = nil
+
+	// Test: Request bodies are forwarded, sized or chunked
+	_, body = do(t, c, mustRequest(t, "POST", front+"/upload", strings.NewReader("sized")))
+	assert.Contains(t, body, "body=sized\n")
+	assert.Contains(t, body, "content-length=5\n")
+	_, body = do(t, c, mustRequest(t, "PUT", front+"/upload", io.MultiReader(strings.NewReader("chun"), strings.NewReader("ked"))))
+	assert.Contains(t, body, "method=PUT\n")
+	assert.Contains(t, body, "body=chunked\n")
+	assert.Contains(t, body, "transfer-encoding=chunked\n")
+
+	// Test: Host is kept when asked to
+	p.PreserveHost = true
+	_, body = do(t, c, mustRequest(t, "GET", front+"/host", nil))
+	assert.Contains(t, body, "host="+strings.TrimPrefix(front, "http://")+"\n")
+
+	// Test: Unreachable upstream is a 502
+	down, err := New("http://127.0.0.1:1")
+	require.NoError(t, err)
+	down.ErrorLog = log.New(io.Discard, "", 0)
+	res, _ = do(t, c, mustRequest(t, "GET", serve(t, down.Serve)+"/", nil))
+	assert.Equal(t, response.StatusBadGateway, res.StatusLine.StatusCode)
+
+	// Test: Invalid targets are refused
+	_, err = New("/relative")
+	assert.Error(t, err)
+}
+
+// lengthTrailer counts the body bytes into an x-length trailer.
+type lengthTrailer struct {
+	n int
+}
+
+func (lt *lengthTrailer) Write(p []byte) (int, error) {
+	lt.n += len(p)
+	return len(p), nil
+}
+
+func (lt *lengthTrailer) Trailers() *headers.Headers {
+	return headers.NewHeaders("x-length", strconv.Itoa(lt.n))
+}
+
+func mustRequest(t *testing.T, method, url string, body io.Reader) *client.Request {
+	t.Helper()
+	req, err := client.NewRequest(method, url, body)
+	require.NoError(t, err)
+	return req
+}
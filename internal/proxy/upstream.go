@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpfromtcp/internal/client"
+)
+
+const (
+	// DefaultFailTimeout is how long an upstream is ejected once it reached
+	// MaxFails.
+	DefaultFailTimeout = 10 * time.Second
+	// DefaultHealthCheckInterval is the time between two probes of an
+	// upstream.
+	DefaultHealthCheckInterval = 10 * time.Second
+	// DefaultHealthCheckTimeout bounds the time a probe waits for the
+	// response headers.
+	DefaultHealthCheckTimeout = 5 * time.Second
+)
+
+// Upstream is one of the servers a proxy forwards requests to.
+type Upstream struct {
+	// URL is the target the upstream was configured with.
+	URL *url.URL
+
+	active   atomic.Int64
+	down     atomic.Bool
+	failures atomic.Int64
+	// ejectedUntil is the unix time in nanoseconds at which a passively
+	// ejected upstream becomes available again.
+	ejectedUntil atomic.Int64
+}
+
+// Active returns the number of requests being forwarded to the upstream.
+func (up *Upstream) Active() int64 {
+	return up.active.Load()
+}
+
+// Available reports whether requests can be sent to the upstream: its last
+// health check passed and it is not ejected after failed requests.
+func (up *Upstream) Available() bool {
+	return !up.down.Load() && time.Now().UnixNano() >= up.ejectedUntil.Load()
+}
+
+// failed records a request the upstream could not answer, ejecting it for
+// timeout once it failed maxFails times in a row. A zero maxFails never
+// ejects it.
+func (up *Upstream) failed(maxFails int, timeout time.Duration) {
+	if maxFails <= 0 {
+		return
+	}
+	if up.failures.Add(1) < int64(maxFails) {
+		return
+	}
+	up.failures.Store(0)
+	up.ejectedUntil.Store(time.Now().Add(timeout).UnixNano())
+}
+
+func (up *Upstream) succeeded() {
+	up.failures.Store(0)
+}
+
+// HealthCheck configures the probes sent by StartHealthChecks.
+type HealthCheck struct {
+	// Path is requested on every upstream, "/" if empty. A 2xx or 3xx
+	// response marks the upstream healthy, anything else unhealthy.
+	Path string
+	// Interval is the time between two probes, DefaultHealthCheckInterval if
+	// zero.
+	Interval time.Duration
+	// Timeout bounds each probe, DefaultHealthCheckTimeout if zero.
+	Timeout time.Duration
+}
+
+// healthChecker runs the probes of a proxy until stopped.
+type healthChecker struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// StartHealthChecks probes every upstream right away and then periodically in
+// the background, taking those failing out of rotation until a probe
+// succeeds again. It replaces the checks started by an earlier call; Close
+// stops them.
+func (p *Proxy) StartHealthChecks(check HealthCheck) {
+	if check.Path == "" {
+		check.Path = "/"
+	}
+	if check.Interval <= 0 {
+		check.Interval = DefaultHealthCheckInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultHealthCheckTimeout
+	}
+
+	c := client.New()
+	c.DialTimeout = check.Timeout
+	c.ResponseHeaderTimeout = check.Timeout
+	c.TLSConfig = p.Client.TLSConfig
+
+	hc := &healthChecker{stop: make(chan struct{})}
+	p.mu.Lock()
+	previous := p.checker
+	p.checker = hc
+	p.mu.Unlock()
+	previous.close()
+
+	for _, up := range p.upstreams {
+		hc.wg.Add(1)
+		go func() {
+			defer hc.wg.Done()
+			defer c.CloseIdleConnections()
+
+			ticker := time.NewTicker(check.Interval)
+			defer ticker.Stop()
+			for {
+				healthy := p.probe(c, up, check.Path)
+				if healthy == up.down.Load() {
+					p.logf("proxy: upstream %s healthy: %t", up.URL, healthy)
+				}
+				up.down.Store(!healthy)
+
+				select {
+				case <-hc.stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// probe reports whether up answers a GET for path with a 2xx or 3xx status.
+func (p *Proxy) probe(c *client.Client, up *Upstream, path string) bool {
+	u := *up.URL
+	u.Path = joinPath(up.URL.Path, path)
+	u.RawPath = ""
+	u.RawQuery = ""
+
+	res, err := c.Get(u.String())
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusLine.StatusCode >= 200 && res.StatusLine.StatusCode < 400
+}
+
+// close stops the probes and waits for those running to return. It is a no-op
+// on a nil checker.
+func (hc *healthChecker) close() {
+	if hc == nil {
+		return
+	}
+	close(hc.stop)
+	hc.wg.Wait()
+}
+
+// Close stops the health checks and closes the idle upstream connections.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	hc := p.checker
+	p.checker = nil
+	p.mu.Unlock()
+	hc.close()
+
+	p.Client.CloseIdleConnections()
+	return nil
+}
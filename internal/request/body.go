@@ -0,0 +1,87 @@
+package request
+
+import (
+	"errors"
+	"io"
+
+	"httpfromtcp/internal/wire"
+)
+
+// noBody is the Body of requests that carry none.
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// body reads the request body from the connection on demand, setting the
+// trailers of the request once it is read.
+type body struct {
+	req  *Request
+	wire *wire.Body
+	// err is set when the 100 Continue could not be sent.
+	err error
+	// closed is set when the body is closed before it was asked for, and so
+	// left on the connection.
+	closed bool
+	// sendContinue is called before the first read, see Request.SetContinue.
+	sendContinue func() error
+}
+
+// newBody returns the decoder of the body announced by the headers of r.
+func (r *Request) newBody(src *wire.Buffer) *wire.Body {
+	if r.chunked {
+		return wire.NewChunkedBody(src, wire.Limits{
+			MaxBodyBytes:    r.limits.MaxBodyBytes,
+			MaxTrailerBytes: r.limits.MaxHeaderBytes,
+			MaxTrailerCount: r.limits.MaxHeaderCount,
+		})
+	}
+	return wire.NewContentBody(src, r.contentLength)
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, wire.ErrClosed
+	}
+	if b.sendContinue != nil && len(p) > 0 {
+		sendContinue := b.sendContinue
+		b.sendContinue = nil
+		b.err = sendContinue()
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.wire.Read(p)
+	if err == io.EOF {
+		b.req.Trailers = b.wire.Trailers()
+	}
+	return n, bodyError(err)
+}
+
+// Close discards what is left of the body so the next request on the
+// connection can be read. It returns an error if the body could not be fully
+// consumed, in which case the connection must not be reused.
+func (b *body) Close() error {
+	if b.sendContinue != nil {
+		// the client waits for a 100 Continue before sending the body, there
+		// is nothing to discard
+		b.closed = true
+		return ErrBodyNotSent
+	}
+	err := b.wire.Close()
+	b.req.Trailers = b.wire.Trailers()
+	return bodyError(err)
+}
+
+// bodyError maps the errors of the body decoder to those documented by
+// Limits.
+func bodyError(err error) error {
+	switch {
+	case errors.Is(err, wire.ErrBodyTooLarge):
+		return ErrBodyTooLarge
+	case errors.Is(err, wire.ErrTrailersTooLarge):
+		return ErrHeadersTooLarge
+	}
+	return err
+}
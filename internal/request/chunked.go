@@ -0,0 +1,28 @@
+package request
+
+import (
+	"errors"
+	"strings"
+)
+
+// isChunked reports whether the body is framed with the chunked transfer
+// coding. Chunked must be the final coding, and it cannot be combined with a
+// Content-Length since the two would disagree on where the body ends.
+func (r *Request) isChunked() (bool, error) {
+	te, ok := r.Headers.Get("Transfer-Encoding")
+	if !ok {
+		return false, nil
+	}
+
+	codings := strings.Split(te, ",")
+	last := strings.TrimSpace(codings[len(codings)-1])
+	if !strings.EqualFold(last, "chunked") {
+		return false, errors.New("unsupported transfer-encoding: chunked must be the final coding")
+	}
+
+	if _, ok := r.Headers.Get("Content-Length"); ok {
+		return false, errors.New("both transfer-encoding and content-length present")
+	}
+
+	return true, nil
+}
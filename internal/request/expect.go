@@ -0,0 +1,39 @@
+package request
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrBodyNotSent is returned by Body.Close when the client was waiting for a
+// 100 Continue that was never sent. The client may or may not send the body
+// anyway, so the connection cannot be reused.
+var ErrBodyNotSent = errors.New("body of a request expecting 100-continue was not read")
+
+// Expect returns the expectation sent in the Expect header, lowercased, or ""
+// if there is none. HTTP/1.0 requests have no expectations.
+func (r *Request) Expect() string {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return ""
+	}
+	expect, _ := r.Headers.Get("expect")
+	return strings.ToLower(strings.TrimSpace(expect))
+}
+
+// ExpectsContinue reports whether the client waits for a 100 Continue before
+// sending the body.
+func (r *Request) ExpectsContinue() bool {
+	return r.Expect() == "100-continue" && r.state != requestStateDone
+}
+
+// SetContinue registers fn to be called the first time Body is read, before
+// anything is read from the connection, so that a server can send the
+// 100 Continue the client waits for. An error from fn is returned by the read.
+//
+// Closing a body whose fn was never called does not read the body from the
+// connection and returns ErrBodyNotSent.
+func (r *Request) SetContinue(fn func() error) {
+	if b, ok := r.Body.(*body); ok {
+		b.sendContinue = fn
+	}
+}
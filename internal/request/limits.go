@@ -0,0 +1,40 @@
+package request
+
+import "errors"
+
+var (
+	// ErrRequestLineTooLong is returned when the request line exceeds
+	// Limits.MaxRequestLineBytes. Servers answer it with 414.
+	ErrRequestLineTooLong = errors.New("request line too long")
+	// ErrHeadersTooLarge is returned when the header section exceeds
+	// Limits.MaxHeaderBytes or Limits.MaxHeaderCount. Servers answer it
+	// with 431.
+	ErrHeadersTooLarge = errors.New("request header fields too large")
+	// ErrBodyTooLarge is returned when the body exceeds Limits.MaxBodyBytes.
+	// Servers answer it with 413.
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// Limits bounds the size of the requests a Reader accepts. A zero field means
+// no limit.
+type Limits struct {
+	// MaxRequestLineBytes bounds the request line, without its CRLF.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes bounds the header section, and separately the trailer
+	// section of a chunked body, CRLFs included.
+	MaxHeaderBytes int
+	// MaxHeaderCount bounds the number of header field lines, and separately
+	// the number of trailer field lines.
+	MaxHeaderCount int
+	// MaxBodyBytes bounds the body. A Content-Length over the limit fails
+	// ReadRequest; a chunked body over it fails Body.Read.
+	MaxBodyBytes int64
+}
+
+// DefaultLimits are the limits used by NewReader. Bodies are streamed rather
+// than buffered, so their size is not limited by default.
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderBytes:      64 << 10,
+	MaxHeaderCount:      100,
+}
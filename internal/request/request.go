@@ -2,27 +2,55 @@ package request
 
 import (
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"io"
 	"strconv"
 	"strings"
 
 	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/wire"
 )
 
 const bufferSize int = 8
+
+// ErrUnsupportedVersion is returned for requests with a well-formed HTTP
+// version other than 1.0 or 1.1.
+var ErrUnsupportedVersion = errors.New("unsupported http version")
+
 const (
 	requestInit = iota
 	requestParsingHeaders
+	// requestParsingBody is set once the headers announced a body, which is
+	// then read through Body.
 	requestParsingBody
 	requestStateDone
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
-	state       int
+	// Target is RequestLine.RequestTarget split into its parts.
+	Target  Target
+	Headers *headers.Headers
+	// Body streams the request body from the connection. It is never nil;
+	// requests without a body get one that returns io.EOF immediately.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields sent after a chunked body. It is only
+	// populated once Body has been read to io.EOF.
+	Trailers *headers.Headers
+	// Params holds the path parameters captured by the router that matched
+	// the request.
+	Params map[string]string
+	// RemoteAddr is the address of the client, set by the server.
+	RemoteAddr string
+	// TLS describes the connection the request came over, set by the server
+	// for HTTPS requests and nil otherwise.
+	TLS           *tls.ConnectionState
+	state         int
+	limits        Limits
+	headerBytes   int
+	chunked       bool
+	contentLength int64
 }
 
 type RequestLine struct {
@@ -31,58 +59,100 @@ type RequestLine struct {
 	Method        string
 }
 
+// Reader parses successive requests from a single connection. Bytes read past
+// the end of one request are kept for the next call to ReadRequest.
+type Reader struct {
+	// Limits bounds the requests read. It is set to DefaultLimits by
+	// NewReader.
+	Limits Limits
+	buf    *wire.Buffer
+}
+
+func NewReader(src io.Reader) *Reader {
+	return &Reader{
+		Limits: DefaultLimits,
+		buf:    wire.NewBuffer(src, bufferSize),
+	}
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+	return NewReader(reader).ReadRequest()
+}
 
+// ReadRequest parses the request line and headers of the next request on the
+// connection. The body is left on the connection to be read through
+// Request.Body, which must be consumed or closed before the next call.
+//
+// It returns io.EOF if the connection was closed before any byte of a new
+// request was read, and io.ErrUnexpectedEOF if it was closed before the end
+// of the headers.
+func (rr *Reader) ReadRequest() (*Request, error) {
 	var req Request = Request{
-		state: requestInit,
-		Body:  nil,
+		state:   requestInit,
+		limits:  rr.Limits,
+		Headers: headers.NewHeaders(),
+		Body:    noBody{},
 	}
 
-	for req.state != requestStateDone {
-		read, err := reader.Read(buf[readToIndex:])
+	for req.state < requestParsingBody {
+		parsed, err := req.parse(rr.buf.Bytes())
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				if req.state == requestParsingBody {
-					if strconv.Itoa(len(req.Body)) != req.Headers["content-length"] {
-						return nil, errors.New("unexpected EOF while reading body")
-					}
-				}
-				req.state = requestStateDone
-				break
-			}
 			return nil, err
 		}
-		readToIndex += read
 
-		parsed, err := req.parse(buf[:readToIndex])
+		if parsed > 0 {
+			rr.buf.Consume(parsed)
+			continue
+		}
+		if req.state >= requestParsingBody {
+			break
+		}
+
+		err = rr.buf.Fill()
+		if errors.Is(err, io.EOF) {
+			if req.state == requestInit {
+				if len(rr.buf.Bytes()) == 0 {
+					return nil, io.EOF
+				}
+				return nil, errors.New("unexpected EOF while reading request-line")
+			}
+			// the headers are only complete with the empty line ending them
+			return nil, io.ErrUnexpectedEOF
+		}
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		if parsed > 0 {
-			newBuf := make([]byte, readToIndex-parsed)
-			copy(newBuf, buf[parsed:])
-			buf = newBuf
-			readToIndex -= parsed
+	if req.state != requestStateDone {
+		req.Body = &body{req: &req, wire: req.newBody(rr.buf)}
+	}
 
-			if req.state == requestStateDone {
-				break
-			}
-		} else {
-			newBuf := make([]byte, len(buf)+bufferSize)
-			copy(newBuf, buf)
-			buf = newBuf
+	return &req, nil
+}
+
+// WaitForRequest blocks until the first byte of the next request is available,
+// so that a server can tell a connection idle between requests from one that
+// is sending a request. It returns io.EOF if the connection is closed first.
+func (rr *Reader) WaitForRequest() error {
+	for len(rr.buf.Bytes()) == 0 {
+		if err := rr.buf.Fill(); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return &req, nil
+// Param returns the path parameter captured under name, or "" if there is none.
+func (r *Request) Param(name string) string {
+	return r.Params[name]
 }
 
+// parse runs the state machine over the request line and headers, stopping
+// once the body framing is known.
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
-	for r.state != requestStateDone {
+	for r.state < requestParsingBody {
 		n, err := r.parseNext(data[totalBytesParsed:])
 		totalBytesParsed += n
 
@@ -102,10 +172,17 @@ func (r *Request) parse(data []byte) (int, error) {
 func (r *Request) parseNext(data []byte) (int, error) {
 	switch r.state {
 	case requestInit:
+		// clients may send a CRLF after a body, ahead of the next request
+		if bytes.HasPrefix(data, []byte("\r\n")) {
+			return 2, nil
+		}
 		read, err := r.parseRequestLine(data)
 		if err != nil {
 			return 0, err
 		}
+		if max := r.limits.MaxRequestLineBytes; max > 0 && (read-2 > max || read == 0 && len(data) > max) {
+			return 0, ErrRequestLineTooLong
+		}
 		if read == 0 {
 			return 0, nil
 		}
@@ -117,68 +194,65 @@ func (r *Request) parseNext(data []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
-		if read == 0 {
-			return 0, nil
-		}
-		if done {
-			r.state = requestParsingBody
-		}
-		return read, nil
-	case requestParsingBody:
-		read, done, err := r.parseBody(data)
-		if done {
-			r.state = requestStateDone
-			return read, nil
+		if max := r.limits.MaxHeaderBytes; max > 0 && (r.headerBytes+read > max || read == 0 && r.headerBytes+len(data) > max) {
+			return 0, ErrHeadersTooLarge
 		}
-
-		if err != nil {
-			return 0, err
+		if max := r.limits.MaxHeaderCount; max > 0 && r.Headers.Len() > max {
+			return 0, ErrHeadersTooLarge
 		}
 		if read == 0 {
 			return 0, nil
 		}
-
+		r.headerBytes += read
+		if done {
+			if err := r.startBody(); err != nil {
+				return 0, err
+			}
+		}
 		return read, nil
-	case requestStateDone:
-		return 0, errors.New("error: trying to read data in a done state")
 	}
 
 	return 0, errors.New("error: unknown state")
 }
 
-func (r *Request) parseBody(data []byte) (int, bool, error) {
+// startBody picks the body framing from the headers and moves the state
+// machine past the headers.
+func (r *Request) startBody() error {
+	chunked, err := r.isChunked()
+	if err != nil {
+		return err
+	}
+	if chunked {
+		r.chunked = true
+		r.state = requestParsingBody
+		return nil
+	}
+
 	contentLengthStr, ok := r.Headers.Get("Content-Length")
 	if !ok {
-		return 0, true, nil
+		r.state = requestStateDone
+		return nil
 	}
 
-	contentLength, err := strconv.Atoi(contentLengthStr)
+	contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
 	if err != nil || contentLength < 0 {
-		return 0, false, errors.New("invalid content-length header NaN or negative")
+		return errors.New("invalid content-length header NaN or negative")
 	}
 
-	if r.Body == nil {
-		r.Body = make([]byte, 0)
-	}
-
-	r.Body = append(r.Body, data...)
-
-	if len(r.Body) > contentLength {
-		return 0, false, errors.New("body is greater than the content-length specified")
+	if contentLength == 0 {
+		r.state = requestStateDone
+		return nil
 	}
-
-	if len(r.Body) == contentLength {
-		return len(data), true, nil
+	if max := r.limits.MaxBodyBytes; max > 0 && contentLength > max {
+		return ErrBodyTooLarge
 	}
 
-	return len(data), false, nil
+	r.contentLength = contentLength
+	r.state = requestParsingBody
+	return nil
 }
 
 func (r *Request) parseHeaders(data []byte) (int, bool, error) {
-	if r.Headers == nil {
-		r.Headers = headers.NewHeaders()
-	}
-
 	n, done, err := r.Headers.Parse(data)
 	if err != nil {
 		return 0, false, err
@@ -207,6 +281,9 @@ func (r *Request) parseRequestLine(data []byte) (int, error) {
 	}
 
 	method := parts[0]
+	if method == "" {
+		return 0, errors.New("empty method")
+	}
 
 	for i := range method {
 		if method[i] < 65 || method[i] > 90 {
@@ -220,8 +297,13 @@ func (r *Request) parseRequestLine(data []byte) (int, error) {
 		return 0, errors.New("bad http version")
 	}
 
-	if strings.Compare(version[1], "1.1") != 0 {
-		return 0, errors.New("unsupported http version")
+	if version[1] != "1.1" && version[1] != "1.0" {
+		return 0, ErrUnsupportedVersion
+	}
+
+	target, err := ParseTarget(method, parts[1])
+	if err != nil {
+		return 0, err
 	}
 
 	r.RequestLine = RequestLine{
@@ -229,6 +311,7 @@ func (r *Request) parseRequestLine(data []byte) (int, error) {
 		RequestTarget: parts[1],
 		HttpVersion:   version[1],
 	}
+	r.Target = target
 
 	return idx + 2, nil
 }
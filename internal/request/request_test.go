@@ -1,11 +1,17 @@
 package request
 
 import (
+	"bytes"
 	"io"
+	"iter"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/headers"
 )
 
 type chunkReader struct {
@@ -63,6 +69,14 @@ func TestRequestLineParse(t *testing.T) {
 	_, err = RequestFromReader(reader)
 	require.Error(t, err)
 
+	// Test: Empty method
+	reader = &chunkReader{
+		data:            " /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
 	// Test: Good Request line
 	reader = &chunkReader{
 		data:            "POST /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -126,6 +140,25 @@ func TestRequestLineParse(t *testing.T) {
 	require.Error(t, err)
 	require.Nil(t, r)
 
+	// Good HTTP/1.0 Request line
+	reader = &chunkReader{
+		data:            "GET /coffee HTTP/1.0\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+
+	// Unsupported version in Request line
+	reader = &chunkReader{
+		data:            "GET /coffee HTTP/1.2\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+	require.Nil(t, r)
+
 	// Invalid method (unknown) Request line
 	reader = &chunkReader{
 		data:            "FoO /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -145,9 +178,9 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
+	assert.Equal(t, []string{"*/*"}, r.Headers.Values("accept"))
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -165,7 +198,7 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, localhost:42070", r.Headers["host"])
+	assert.Equal(t, []string{"localhost:42069", "localhost:42070"}, r.Headers.Values("host"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
@@ -175,7 +208,7 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Empty(t, r.Headers)
+	assert.Zero(t, r.Headers.Len())
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -185,21 +218,25 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
+	assert.Equal(t, []string{"*/*"}, r.Headers.Values("accept"))
 
 	// Test: Missing End of Headers
 	reader = &chunkReader{
 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n",
 		numBytesPerRead: 3,
 	}
-	r, err = RequestFromReader(reader)
-	require.NoError(t, err)
-	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Conflicting framing cut short before the end of headers
+	reader = &chunkReader{
+		data:            "POST /x HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 }
 
 func TestRequestBodyParse(t *testing.T) {
@@ -215,7 +252,9 @@ func TestRequestBodyParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -226,8 +265,10 @@ func TestRequestBodyParse(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
-	require.Error(t, err)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 
 	// Test: Empty Body, 0 reported content length (valid)
 	reader = &chunkReader{
@@ -237,8 +278,11 @@ func TestRequestBodyParse(t *testing.T) {
 			"\r\n",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
+	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
 
 	// Test: Empty Body, no reported content length (valid)
 	reader = &chunkReader{
@@ -247,8 +291,11 @@ func TestRequestBodyParse(t *testing.T) {
 			"\r\n",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
 	require.NoError(t, err)
+	assert.Empty(t, body)
 
 	// Test: "No Content-Length but Body Exists" (shouldn't error, we're assuming Content-Length will be present if a body exists)
 	reader = &chunkReader{
@@ -261,3 +308,555 @@ func TestRequestBodyParse(t *testing.T) {
 	_, err = RequestFromReader(reader)
 	require.NoError(t, err)
 }
+
+func TestReaderSuccessiveRequests(t *testing.T) {
+	// Test: Two pipelined requests on the same connection
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /coffee HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "POST", r.RequestLine.Method)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "GET", r.RequestLine.Method)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+
+	// Test: Clean EOF once the connection has no more requests
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Empty lines ahead of a request line are skipped
+	reader = NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 2\r\n" +
+			"\r\n" +
+			"hi\r\n" +
+			"\r\n" +
+			"GET /b HTTP/1.1\r\n" +
+			"\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	})
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(body))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: EOF in the middle of a request line
+	reader = NewReader(&chunkReader{
+		data:            "GET /coffee HT",
+		numBytesPerRead: 3,
+	})
+	_, err = reader.ReadRequest()
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+}
+
+func TestRequestChunkedBodyParse(t *testing.T) {
+	// Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5;name=value\r\n" +
+			"hello\r\n" +
+			"7\r\n" +
+			" world!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Zero(t, r.Trailers.Len())
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.Equal(t, []string{"abc123"}, r.Trailers.Values("x-checksum"))
+
+	// Test: Empty chunked body
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+	assert.Zero(t, r.Trailers.Len())
+
+	// Test: Chunked body followed by another request
+	rr := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"A\r\n" +
+			"0123456789\r\n" +
+			"0\r\n" +
+			"\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body))
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\n" +
+			"hello\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Chunk data longer than its size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\n" +
+			"hello\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Connection closed before the last chunk
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Both Transfer-Encoding and Content-Length
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestRequestBodyStreaming(t *testing.T) {
+	// Test: Body is read from the connection on demand
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Less(t, reader.pos, len(reader.data))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(r.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	assert.Less(t, reader.pos, len(reader.data))
+
+	// Test: Closing an unread body leaves the connection at the next request
+	rr := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(buf)
+	require.Error(t, err)
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+}
+
+func TestRequestTargetParse(t *testing.T) {
+	// Test: Origin-form with query and percent-encoding
+	reader := &chunkReader{
+		data:            "GET /files/my%20doc.txt?page=2&tag=a&tag=b%26c HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, r.Target.Form)
+	assert.Equal(t, "/files/my doc.txt", r.Target.Path)
+	assert.Equal(t, "/files/my%20doc.txt", r.Target.RawPath)
+	assert.Equal(t, "page=2&tag=a&tag=b%26c", r.Target.RawQuery)
+	assert.Equal(t, "2", r.Target.Query.Get("page"))
+	assert.Equal(t, []string{"a", "b&c"}, r.Target.Query["tag"])
+
+	// Test: Absolute-form
+	target, err := ParseTarget("GET", "http://example.com:8080/where?q=now#top")
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, target.Form)
+	assert.Equal(t, "http", target.Scheme)
+	assert.Equal(t, "example.com:8080", target.Host)
+	assert.Equal(t, "/where", target.Path)
+	assert.Equal(t, "now", target.Query.Get("q"))
+	assert.Equal(t, "top", target.Fragment)
+
+	// Test: Absolute-form without a path
+	target, err = ParseTarget("GET", "http://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "/", target.Path)
+
+	// Test: Authority-form
+	target, err = ParseTarget("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, target.Form)
+	assert.Equal(t, "example.com:443", target.Host)
+
+	// Test: Asterisk-form
+	target, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, target.Form)
+
+	// Test: Asterisk-form with another method
+	_, err = ParseTarget("GET", "*")
+	require.Error(t, err)
+
+	// Test: Invalid percent-encoding
+	reader = &chunkReader{
+		data:            "GET /bad%zzpath HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Query that is not form-encoded keeps what can be decoded
+	for _, raw := range []string{"/s?a=1;b=2", "/s?q=100%", "/s?q=a%zz&page=2"} {
+		target, err = ParseTarget("GET", raw)
+		require.NoError(t, err, raw)
+		assert.Equal(t, "/s", target.Path)
+		assert.Equal(t, raw[len("/s?"):], target.RawQuery)
+	}
+	assert.Equal(t, "2", target.Query.Get("page"))
+	assert.False(t, target.Query.Has("q"))
+
+	// Test: Relative target
+	_, err = ParseTarget("GET", "coffee")
+	require.Error(t, err)
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        10,
+	}
+	read := func(data string) (*Request, error) {
+		reader := NewReader(&chunkReader{data: data, numBytesPerRead: 5})
+		reader.Limits = limits
+		return reader.ReadRequest()
+	}
+
+	// Test: Request within every limit
+	r, err := read("POST /coffee HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 10\r\n\r\n0123456789")
+	require.NoError(t, err)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body))
+
+	// Test: Request line too long, even before its CRLF arrives
+	_, err = read("GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\n\r\n")
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+	_, err = read("GET /" + strings.Repeat("a", 40))
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Header section too large
+	_, err = read("GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", 70) + "\r\n\r\n")
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Too many header lines
+	_, err = read("GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n")
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Content-Length over the limit fails before the body is read
+	_, err = read("POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\n01234567890")
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body over the limit fails while reading it
+	r, err = read("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n6\r\n012345\r\n6\r\n012345\r\n0\r\n\r\n")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Zero limits mean no limit
+	reader := NewReader(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 100<<10) + " HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 4096,
+	})
+	reader.Limits = Limits{}
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+}
+
+func TestRequestExpectContinue(t *testing.T) {
+	// Test: Continue is sent once, on the first read of the body
+	r, err := RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+	calls := 0
+	r.SetContinue(func() error {
+		calls++
+		return nil
+	})
+	assert.Equal(t, 0, calls)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, r.Body.Close())
+
+	// Test: Closing an unread body without continuing does not read it
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	r.SetContinue(func() error { return nil })
+	assert.ErrorIs(t, r.Body.Close(), ErrBodyNotSent)
+
+	// Test: Failing to continue fails the read
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	r.SetContinue(func() error { return io.ErrClosedPipe })
+	_, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+
+	// Test: HTTP/1.0 requests have no expectations
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "", r.Expect())
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: Request without a body does not wait for a continue
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+}
+
+func TestRequestWriteTo(t *testing.T) {
+	// Test: Request without a body
+	r := &Request{
+		RequestLine: RequestLine{Method: "GET", RequestTarget: "/coffee?size=large"},
+		Headers:     headers.NewHeaders(),
+	}
+	r.Headers.Add("Host", "localhost:42069")
+	r.Headers.Add("Accept", "text/plain")
+	r.Headers.Add("Accept", "text/html")
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /coffee?size=large HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Accept: text/plain\r\n"+
+		"Accept: text/html\r\n"+
+		"\r\n", b.String())
+	assert.Equal(t, int64(b.Len()), n)
+
+	// Test: Content-Length body is cut to the declared length
+	r = &Request{
+		RequestLine: RequestLine{Method: "POST", RequestTarget: "/upload", HttpVersion: "1.0"},
+		Headers:     headers.NewHeaders(),
+		Body:        io.NopCloser(strings.NewReader("hello world")),
+	}
+	r.Headers.Set("Content-Length", "5")
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /upload HTTP/1.0\r\nContent-Length: 5\r\n\r\nhello", b.String())
+
+	// Test: Body shorter than Content-Length
+	r.Body = io.NopCloser(strings.NewReader("hey"))
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.Error(t, err)
+
+	// Test: Chunked body with trailers
+	r = &Request{
+		RequestLine: RequestLine{Method: "PUT", RequestTarget: "/upload"},
+		Headers:     headers.NewHeaders(),
+		Body:        io.NopCloser(io.MultiReader(strings.NewReader("hello"), strings.NewReader(" world!"))),
+		Trailers:    headers.NewHeaders(),
+	}
+	r.Headers.Set("Transfer-Encoding", "chunked")
+	r.Trailers.Set("X-Checksum", "abc123")
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "PUT /upload HTTP/1.1\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"5\r\nhello\r\n"+
+		"7\r\n world!\r\n"+
+		"0\r\n"+
+		"X-Checksum: abc123\r\n"+
+		"\r\n", b.String())
+
+	// Test: Head and chunks are written before the body ends
+	bodyReader, bodyWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	r = &Request{
+		RequestLine: RequestLine{Method: "POST", RequestTarget: "/upload"},
+		Headers:     headers.NewHeaders(),
+		Body:        bodyReader,
+	}
+	r.Headers.Set("Transfer-Encoding", "chunked")
+	go func() {
+		_, err := r.WriteTo(outWriter)
+		outWriter.CloseWithError(err)
+	}()
+	head := "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"
+	got := make([]byte, len(head))
+	_, err = io.ReadFull(outReader, got)
+	require.NoError(t, err)
+	assert.Equal(t, head, string(got))
+	go bodyWriter.Write([]byte("hello"))
+	got = make([]byte, len("5\r\nhello\r\n"))
+	_, err = io.ReadFull(outReader, got)
+	require.NoError(t, err)
+	assert.Equal(t, "5\r\nhello\r\n", string(got))
+	bodyWriter.Close()
+	rest, err := io.ReadAll(outReader)
+	require.NoError(t, err)
+	assert.Equal(t, "0\r\n\r\n", string(rest))
+
+	// Test: Invalid framing is refused
+	r = &Request{
+		RequestLine: RequestLine{Method: "POST", RequestTarget: "/"},
+		Headers:     headers.NewHeaders(),
+	}
+	r.Headers.Set("Content-Length", "-1")
+	_, err = r.WriteTo(io.Discard)
+	require.Error(t, err)
+	r.Headers.Set("Transfer-Encoding", "chunked")
+	_, err = r.WriteTo(io.Discard)
+	require.Error(t, err)
+	_, err = (&Request{Headers: headers.NewHeaders()}).WriteTo(io.Discard)
+	require.Error(t, err)
+
+	// Test: Parsed requests are written back as they were read
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		"POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Checksum: abc123\r\n\r\n",
+		"OPTIONS * HTTP/1.0\r\n\r\n",
+	} {
+		r, err := RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		b.Reset()
+		_, err = r.WriteTo(&b)
+		require.NoError(t, err)
+		assert.Equal(t, raw, b.String())
+	}
+}
+
+// FuzzRequestRoundTrip checks that writing a parsed request and parsing it
+// again gives back the same request.
+func FuzzRequestRoundTrip(f *testing.F) {
+	f.Add("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	f.Add("POST /submit?a=b HTTP/1.0\r\nContent-Length: 5\r\n\r\nhello")
+	f.Add("PUT /upload HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n3;x=y\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\n")
+	f.Add("CONNECT example.com:443 HTTP/1.1\r\n\r\n")
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		r, err := RequestFromReader(strings.NewReader(raw))
+		if err != nil {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var b strings.Builder
+		_, err = r.WriteTo(&b)
+		require.NoError(t, err)
+
+		again, err := RequestFromReader(strings.NewReader(b.String()))
+		require.NoError(t, err)
+		againBody, err := io.ReadAll(again.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, r.RequestLine, again.RequestLine)
+		assert.Equal(t, slices.Collect(pairs(r.Headers)), slices.Collect(pairs(again.Headers)))
+		assert.Equal(t, body, againBody)
+		assert.Equal(t, slices.Collect(pairs(r.Trailers)), slices.Collect(pairs(again.Trailers)))
+	})
+}
+
+func pairs(h *headers.Headers) iter.Seq[[2]string] {
+	return func(yield func([2]string) bool) {
+		for k, v := range h.All() {
+			if !yield([2]string{k, v}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package request
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// TargetForm is one of the four forms a request target can take (RFC 9112
+// section 3.2).
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path with an optional query: /where?q=now.
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a full URI, sent to proxies: http://example.com/where.
+	AbsoluteForm
+	// AuthorityForm is a host and port, only used by CONNECT.
+	AuthorityForm
+	// AsteriskForm is "*", only used by a server-wide OPTIONS.
+	AsteriskForm
+)
+
+// Target is the parsed request target.
+type Target struct {
+	Form TargetForm
+	// Scheme is set for the absolute form.
+	Scheme string
+	// Host is set for the absolute and authority forms.
+	Host string
+	// Path is the percent-decoded path. RawPath is the path as it was sent.
+	Path     string
+	RawPath  string
+	RawQuery string
+	Fragment string
+	// Query holds the decoded query parameters. Pairs that are not valid
+	// form encoding are left out.
+	Query url.Values
+}
+
+// ParseTarget parses the request target of a request with the given method.
+func ParseTarget(method, raw string) (Target, error) {
+	if raw == "" {
+		return Target{}, errors.New("empty request target")
+	}
+	for i := 0; i < len(raw); i++ {
+		if raw[i] <= ' ' || raw[i] == 0x7f {
+			return Target{}, errors.New("invalid character in request target")
+		}
+	}
+
+	switch {
+	case raw == "*":
+		if method != "OPTIONS" {
+			return Target{}, errors.New("asterisk-form target is only allowed for OPTIONS")
+		}
+		return Target{Form: AsteriskForm, Query: url.Values{}}, nil
+	case method == "CONNECT":
+		if !strings.Contains(raw, ":") || strings.ContainsAny(raw, "/?#") {
+			return Target{}, errors.New("CONNECT target must be host:port")
+		}
+		return Target{Form: AuthorityForm, Host: raw, Query: url.Values{}}, nil
+	case strings.HasPrefix(raw, "/"):
+		return parsePathAndQuery(Target{Form: OriginForm}, raw)
+	}
+
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok || !validScheme(scheme) {
+		return Target{}, errors.New("malformed request target")
+	}
+
+	end := strings.IndexAny(rest, "/?#")
+	if end == -1 {
+		end = len(rest)
+	}
+	host := rest[:end]
+	if host == "" {
+		return Target{}, errors.New("absolute-form target without host")
+	}
+
+	pathAndQuery := rest[end:]
+	if !strings.HasPrefix(pathAndQuery, "/") {
+		pathAndQuery = "/" + pathAndQuery
+	}
+
+	return parsePathAndQuery(Target{
+		Form:   AbsoluteForm,
+		Scheme: strings.ToLower(scheme),
+		Host:   host,
+	}, pathAndQuery)
+}
+
+func parsePathAndQuery(target Target, raw string) (Target, error) {
+	raw, fragment, _ := strings.Cut(raw, "#")
+	rawPath, rawQuery, _ := strings.Cut(raw, "?")
+
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return Target{}, errors.New("invalid percent-encoding in request path")
+	}
+	// a query that is not form-encoded is still a valid target; whatever
+	// could be decoded is kept and RawQuery has the rest
+	query, _ := url.ParseQuery(rawQuery)
+
+	target.Path = path
+	target.RawPath = rawPath
+	target.RawQuery = rawQuery
+	target.Fragment = fragment
+	target.Query = query
+
+	return target, nil
+}
+
+func validScheme(scheme string) bool {
+	if scheme == "" {
+		return false
+	}
+	for i := 0; i < len(scheme); i++ {
+		c := scheme[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package request
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WriteTo writes the request to w in wire format: the request line, the
+// headers in their order and the body, framed as the headers declare it.
+// With Transfer-Encoding the body is sent chunked, followed by Trailers;
+// with Content-Length exactly that many bytes of Body are sent; with neither
+// the body is left out. Body is read but not closed.
+//
+// The head is flushed before the body is read, and every chunk as soon as it
+// was read, so a body that trickles in is passed on as it arrives.
+//
+// An empty HttpVersion is written as HTTP/1.1.
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	if r.RequestLine.Method == "" || r.RequestLine.RequestTarget == "" {
+		return 0, errors.New("request line missing method or target")
+	}
+	if r.Headers == nil {
+		return 0, errors.New("request has no headers")
+	}
+
+	chunked, err := r.isChunked()
+	if err != nil {
+		return 0, err
+	}
+	contentLength := int64(0)
+	if value, ok := r.Headers.Get("Content-Length"); ok && !chunked {
+		contentLength, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || contentLength < 0 {
+			return 0, errors.New("invalid content-length header NaN or negative")
+		}
+	}
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	version := r.RequestLine.HttpVersion
+	if version == "" {
+		version = "1.1"
+	}
+	fmt.Fprintf(bw, "%s %s HTTP/%s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, version)
+	for k, v := range r.Headers.All() {
+		fmt.Fprintf(bw, "%s: %s\r\n", k, v)
+	}
+	io.WriteString(bw, "\r\n")
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+
+	body := r.Body
+	if body == nil {
+		body = noBody{}
+	}
+	switch {
+	case chunked:
+		err = r.writeChunked(bw, body)
+	case contentLength > 0:
+		var n int64
+		n, err = io.CopyN(cw, body, contentLength)
+		if err != nil && n < contentLength {
+			err = fmt.Errorf("request body shorter than content-length: %w", err)
+		}
+	}
+	return cw.n, err
+}
+
+// writeChunked writes body with the chunked transfer coding, then the
+// trailers, flushing w after every chunk.
+func (r *Request) writeChunked(w *bufio.Writer, body io.Reader) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			io.WriteString(w, "\r\n")
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.Flush()
+			return err
+		}
+	}
+
+	io.WriteString(w, "0\r\n")
+	// trailers of a parsed request are only known once its body was read
+	if r.Trailers != nil {
+		for k, v := range r.Trailers.All() {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+	}
+	io.WriteString(w, "\r\n")
+	return w.Flush()
+}
+
+// countingWriter counts the bytes written through it, for WriteTo to report.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
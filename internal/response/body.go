@@ -0,0 +1,58 @@
+package response
+
+import (
+	"errors"
+	"io"
+
+	"httpfromtcp/internal/wire"
+)
+
+// NoBody is the Body of responses that carry none.
+var NoBody io.ReadCloser = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// body reads the response body from the connection on demand, setting the
+// trailers of the response once it is read.
+type body struct {
+	res  *Response
+	wire *wire.Body
+}
+
+// newBody returns the decoder of the body announced by the headers of r.
+func (r *Response) newBody(src *wire.Buffer) *wire.Body {
+	switch {
+	case r.chunked:
+		return wire.NewChunkedBody(src, wire.Limits{MaxTrailerBytes: maxHeaderBytes})
+	case r.closeDelimited:
+		return wire.NewCloseDelimitedBody(src)
+	}
+	return wire.NewContentBody(src, r.contentLength)
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.wire.Read(p)
+	if err == io.EOF {
+		b.res.Trailers = b.wire.Trailers()
+	}
+	if errors.Is(err, wire.ErrTrailersTooLarge) {
+		err = ErrHeadersTooLarge
+	}
+	return n, err
+}
+
+// Close discards what is left of the body so the next response on the
+// connection can be read. It returns an error if the body could not be fully
+// consumed, in which case the connection must not be reused. A body delimited
+// by closing the connection is not drained.
+func (b *body) Close() error {
+	err := b.wire.Close()
+	b.res.Trailers = b.wire.Trailers()
+	if errors.Is(err, wire.ErrTrailersTooLarge) {
+		err = ErrHeadersTooLarge
+	}
+	return err
+}
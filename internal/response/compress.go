@@ -0,0 +1,139 @@
+package response
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+)
+
+// minCompressLength is the content-length below which compressing a body is
+// not worth it.
+const minCompressLength = 256
+
+// incompressibleTypes are content types that are already compressed. Types
+// ending in "/" match a whole family.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+}
+
+// compressibleImages are exceptions to the image/ family: text formats.
+var compressibleImages = []string{"image/svg+xml", "image/bmp", "image/x-icon"}
+
+// EnableCompression makes the Writer compress the body with encoding, "gzip"
+// or "deflate", which the client must have accepted. It must be called before
+// the headers are written.
+//
+// Whether the body is actually compressed is decided by WriteHeaders: bodies
+// that are empty, small, partial, already encoded or of an already
+// compressed content type are written as is. A compressed body replaces the
+// content-length with chunked framing, and the handler writes it the same
+// way it would have written the uncompressed one.
+func (w *Writer) EnableCompression(encoding string) error {
+	if w.writerStatus > writerHeaders {
+		return fmt.Errorf("invalid writer status: %v", w.writerStatus)
+	}
+	switch encoding {
+	case "gzip", "deflate":
+		w.encoding = encoding
+		return nil
+	}
+	return fmt.Errorf("unsupported content-encoding: %q", encoding)
+}
+
+// compressible reports whether a body described by h, with the given framing,
+// should be compressed.
+func (w *Writer) compressible(h *headers.Headers, framing framing, contentLength int) bool {
+	if w.encoding == "" || framing == framingNone || w.statusCode == StatusPartialContent {
+		return false
+	}
+	if framing == framingContentLength && contentLength < minCompressLength {
+		return false
+	}
+	if _, ok := h.Get("content-encoding"); ok {
+		return false
+	}
+	if _, ok := h.Get("content-range"); ok {
+		return false
+	}
+
+	contentType, _ := h.Get("content-type")
+	contentType, _, _ = strings.Cut(strings.ToLower(contentType), ";")
+	contentType = strings.TrimSpace(contentType)
+	for _, t := range compressibleImages {
+		if contentType == t {
+			return true
+		}
+	}
+	for _, t := range incompressibleTypes {
+		if contentType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressedHeaders returns h adjusted for a body compressed with encoding.
+func compressedHeaders(h *headers.Headers, encoding string) *headers.Headers {
+	h = h.Clone()
+	h.Del("content-length")
+	h.Set("transfer-encoding", "chunked")
+	h.Set("content-encoding", encoding)
+	h.Add("vary", "accept-encoding")
+	// the compressed body is not byte for byte the one the tag was made for
+	if etag, ok := h.Get("etag"); ok && !strings.HasPrefix(etag, "W/") {
+		h.Set("etag", "W/"+etag)
+	}
+	return h
+}
+
+func newEncoder(encoding string, dst io.Writer) io.WriteCloser {
+	if encoding == "deflate" {
+		// HTTP deflate is the zlib format
+		return zlib.NewWriter(dst)
+	}
+	return gzip.NewWriter(dst)
+}
+
+// chunkWriter writes what it is given as chunks of the response body.
+type chunkWriter struct {
+	w *Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	if _, err := cw.w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// endCompressed flushes what is left of a compressed body. A content-length
+// or close-delimited body is then over, and gets its last chunk and the end
+// of the trailers.
+func (w *Writer) endCompressed() error {
+	enc := w.enc
+	w.enc = nil
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if w.framing == framingChunked || w.unchunked {
+		return nil
+	}
+	_, err := io.WriteString(w.conn, "0\r\n\r\n")
+	return err
+}
@@ -0,0 +1,266 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/wire"
+)
+
+const readBufferSize = 4096
+
+// maxHeaderBytes bounds the status line and headers of a response, and its
+// trailers, so a misbehaving server cannot make the reader buffer forever.
+const maxHeaderBytes = 1 << 20
+
+var ErrHeadersTooLarge = errors.New("response headers too large")
+
+const (
+	responseInit = iota
+	responseParsingHeaders
+	// responseParsingBody is set once the headers announced a body, which is
+	// then read through Body.
+	responseParsingBody
+	responseStateDone
+)
+
+// Response is a response read from a connection, the client side counterpart
+// of request.Request.
+type Response struct {
+	StatusLine StatusLine
+	Headers    *headers.Headers
+	// Body streams the response body from the connection. It is never nil;
+	// responses without a body get one that returns io.EOF immediately.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields sent after a chunked body. It is only
+	// populated once Body has been read to io.EOF.
+	Trailers       *headers.Headers
+	state          int
+	headerBytes    int
+	chunked        bool
+	closeDelimited bool
+	contentLength  int64
+}
+
+type StatusLine struct {
+	HttpVersion string
+	StatusCode  StatusCode
+	Reason      string
+}
+
+// CloseDelimited reports whether the body ends when the server closes the
+// connection, which then cannot carry another response.
+func (r *Response) CloseDelimited() bool {
+	return r.closeDelimited
+}
+
+// Reader parses successive responses from a single connection. Bytes read
+// past the end of one response are kept for the next call to ReadResponse.
+type Reader struct {
+	buf *wire.Buffer
+}
+
+func NewReader(src io.Reader) *Reader {
+	return &Reader{
+		buf: wire.NewBuffer(src, readBufferSize),
+	}
+}
+
+// ResponseFromReader reads a single response to a request made with method.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	return NewReader(reader).ReadResponse(method)
+}
+
+// ReadResponse parses the status line and headers of the next response on the
+// connection, the answer to a request made with method, which tells whether
+// the response has a body. Interim 1xx responses are skipped. The body is left
+// on the connection to be read through Response.Body, which must be consumed
+// or closed before the next call.
+//
+// It returns io.EOF if the connection was closed before any byte of a new
+// response was read.
+func (rr *Reader) ReadResponse(method string) (*Response, error) {
+	for {
+		res, err := rr.readHead(method)
+		if err != nil {
+			return nil, err
+		}
+		code := res.StatusLine.StatusCode
+		if code < 200 && code != StatusSwitchingProtocols {
+			continue
+		}
+
+		if res.state != responseStateDone {
+			res.Body = &body{res: res, wire: res.newBody(rr.buf)}
+		}
+		return res, nil
+	}
+}
+
+func (rr *Reader) readHead(method string) (*Response, error) {
+	res := &Response{
+		state:   responseInit,
+		Headers: headers.NewHeaders(),
+		Body:    NoBody,
+	}
+
+	for res.state < responseParsingBody {
+		parsed, err := res.parse(rr.buf.Bytes(), method)
+		if err != nil {
+			return nil, err
+		}
+
+		if parsed > 0 {
+			rr.buf.Consume(parsed)
+			continue
+		}
+		if res.state >= responseParsingBody {
+			break
+		}
+
+		err = rr.buf.Fill()
+		if errors.Is(err, io.EOF) {
+			if res.state == responseInit && len(rr.buf.Bytes()) == 0 {
+				return nil, io.EOF
+			}
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// parse runs the state machine over the status line and headers, stopping
+// once the body framing is known.
+func (r *Response) parse(data []byte, method string) (int, error) {
+	totalBytesParsed := 0
+	for r.state < responseParsingBody {
+		n, err := r.parseNext(data[totalBytesParsed:], method)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		totalBytesParsed += n
+	}
+	return totalBytesParsed, nil
+}
+
+func (r *Response) parseNext(data []byte, method string) (int, error) {
+	switch r.state {
+	case responseInit:
+		read, err := r.parseStatusLine(data)
+		if err != nil {
+			return 0, err
+		}
+		if read-2 > maxHeaderBytes || read == 0 && len(data) > maxHeaderBytes {
+			return 0, ErrHeadersTooLarge
+		}
+		if read == 0 {
+			return 0, nil
+		}
+		r.state = responseParsingHeaders
+		return read, nil
+	case responseParsingHeaders:
+		read, done, err := r.Headers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if r.headerBytes+read > maxHeaderBytes || read == 0 && r.headerBytes+len(data) > maxHeaderBytes {
+			return 0, ErrHeadersTooLarge
+		}
+		if read == 0 {
+			return 0, nil
+		}
+		r.headerBytes += read
+		if done {
+			if err := r.startBody(method); err != nil {
+				return 0, err
+			}
+		}
+		return read, nil
+	}
+
+	return 0, errors.New("error: unknown state")
+}
+
+// startBody picks the body framing from the request method, the status and
+// the headers, and moves the state machine past the headers.
+func (r *Response) startBody(method string) error {
+	code := r.StatusLine.StatusCode
+	if method == "HEAD" || code < 200 || code == StatusNoContent || code == StatusNotModified {
+		r.state = responseStateDone
+		return nil
+	}
+
+	if te, ok := r.Headers.Get("transfer-encoding"); ok {
+		codings := strings.Split(te, ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			r.chunked = true
+		} else {
+			// any other final coding is delimited by closing the connection
+			r.closeDelimited = true
+		}
+		r.state = responseParsingBody
+		return nil
+	}
+
+	contentLengthStr, ok := r.Headers.Get("content-length")
+	if !ok {
+		r.closeDelimited = true
+		r.state = responseParsingBody
+		return nil
+	}
+
+	contentLength, err := strconv.ParseInt(strings.TrimSpace(contentLengthStr), 10, 64)
+	if err != nil || contentLength < 0 {
+		return fmt.Errorf("invalid content-length: %q", contentLengthStr)
+	}
+	if contentLength == 0 {
+		r.state = responseStateDone
+		return nil
+	}
+
+	r.contentLength = contentLength
+	r.state = responseParsingBody
+	return nil
+}
+
+func (r *Response) parseStatusLine(data []byte) (int, error) {
+	idx := bytes.Index(data, []byte("\r\n"))
+	if idx == -1 {
+		return 0, nil
+	}
+
+	line := string(data[:idx])
+	version, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return 0, errors.New("malformed status-line")
+	}
+	if version != "HTTP/1.1" && version != "HTTP/1.0" {
+		return 0, fmt.Errorf("unsupported http version: %q", version)
+	}
+
+	code, reason, _ := strings.Cut(rest, " ")
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || len(code) != 3 || statusCode < 100 {
+		return 0, fmt.Errorf("invalid status code: %q", code)
+	}
+
+	r.StatusLine = StatusLine{
+		HttpVersion: strings.TrimPrefix(version, "HTTP/"),
+		StatusCode:  StatusCode(statusCode),
+		Reason:      reason,
+	}
+
+	return idx + 2, nil
+}
@@ -1,8 +1,11 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"httpfromtcp/internal/headers"
 )
@@ -13,122 +16,501 @@ const (
 	writerInit writerStatus = iota
 	writerHeaders
 	writerBody
+	writerTrailers
 	writerDone
 )
 
-type Writer struct {
-	io.Writer
-	writerStatus writerStatus
-}
-
-type StatusCode int
+// framing is how the end of the body is signalled to the client, as committed
+// to by the headers.
+type framing int
 
 const (
-	StatusOK         StatusCode = 200
-	StatusBadRequest StatusCode = 400
-	StatusInternal   StatusCode = 500
+	// framingNone is for responses that cannot have a body.
+	framingNone framing = iota
+	framingContentLength
+	framingChunked
+	// framingClose ends the body by closing the connection.
+	framingClose
 )
 
+var (
+	ErrBodyTooLong    = errors.New("body write exceeds the declared content-length")
+	ErrBodyIncomplete = errors.New("body is shorter than the declared content-length")
+	ErrNotChunked     = errors.New("chunked write without transfer-encoding: chunked")
+	ErrChunked        = errors.New("body write must use WriteChunkedBody with transfer-encoding: chunked")
+	ErrBodyNotAllowed = errors.New("response status does not allow a body")
+	ErrAborted        = errors.New("response was aborted")
+)
+
+// Writer writes a response in order: status line, headers, body and, for
+// chunked bodies, trailers. The headers commit the writer to a framing
+// (content-length, chunked or close-delimited), and body writes are checked
+// against it.
+type Writer struct {
+	conn         io.Writer
+	writerStatus writerStatus
+	keepAlive    bool
+	statusCode   StatusCode
+	header       *headers.Headers
+	framing      framing
+	remaining    int
+	aborted      bool
+	version      string
+	// unchunked is set when a chunked body is written to an HTTP/1.0 client,
+	// which does not understand chunking: the chunks are written as is and
+	// the body ends by closing the connection.
+	unchunked bool
+	// head is set for responses to HEAD requests, whose body is discarded.
+	head bool
+	// encoding is the content-encoding set by EnableCompression, and enc the
+	// compressor body bytes go through once the headers decided to use it.
+	// The framing then remains the one declared by the handler, for checking
+	// its writes, while the body goes out chunked.
+	encoding string
+	enc      io.WriteCloser
+}
+
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		Writer:       w,
+		conn:         w,
 		writerStatus: writerInit,
+		version:      "1.1",
+	}
+}
+
+// SetVersion sets the HTTP version of the response, which should be the one
+// of the request ("1.0" or "1.1"). It must be called before the status line
+// is written.
+func (w *Writer) SetVersion(version string) {
+	w.version = version
+}
+
+// SetHead marks the response as answering a HEAD request. The headers are
+// written as they would be for GET, content-length included, but body writes
+// are discarded so handlers do not need to special-case HEAD.
+func (w *Writer) SetHead(head bool) {
+	w.head = head
+}
+
+// SetKeepAlive sets whether the connection may be reused once the response is
+// written. It only has an effect before the headers are written.
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// KeepAlive reports whether the connection may be reused for another request.
+// Writing a "connection: close" header, or headers that delimit the body by
+// closing the connection, turns it off.
+func (w *Writer) KeepAlive() bool {
+	return w.keepAlive
+}
+
+// StatusCode returns the status written with WriteStatusLine, or 0 if the
+// response has not been started yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// Header returns headers that WriteHeaders adds to the ones it is given,
+// unless a header with the same name is already there. Middleware uses it to
+// set headers on responses written by the handlers it wraps.
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
 	}
+	return w.header
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineReason writes a status line with a custom reason phrase
+// instead of the standard one for statusCode.
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
 	if w.writerStatus != writerInit {
 		return fmt.Errorf("invalid writer status: %v", w.writerStatus)
 	}
-
-	var reason string
-	switch statusCode {
-	case StatusOK:
-		reason = "OK"
-	case StatusBadRequest:
-		reason = "Bad Request"
-	case StatusInternal:
-		reason = "Internal Server Error"
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("invalid status code: %d", statusCode)
 	}
-	_, err := io.WriteString(w, "HTTP/1.1 "+fmt.Sprint(int(statusCode))+" "+reason+"\r\n")
+	if strings.ContainsAny(reason, "\r\n") {
+		return errors.New("reason phrase must not contain CR or LF")
+	}
+
+	_, err := io.WriteString(w.conn, "HTTP/"+w.version+" "+fmt.Sprint(int(statusCode))+" "+reason+"\r\n")
 
 	if err == nil {
 		w.writerStatus = writerHeaders
+		w.statusCode = statusCode
 	}
 
 	return err
 }
 
-func GetDefaultHeaders(contentLength int) headers.Headers {
+func GetDefaultHeaders(contentLength int) *headers.Headers {
 	h := headers.NewHeaders()
-	h["connection"] = "close"
-	h["content-type"] = "text/html"
-	h["content-length"] = fmt.Sprint(contentLength)
+	h.Set("content-type", "text/html")
+	h.Set("content-length", fmt.Sprint(contentLength))
 
 	return h
 }
 
-func (w *Writer) WriteHeaders(h headers.Headers) error {
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
 	if w.writerStatus != writerHeaders {
 		return fmt.Errorf("invalid writer status: %v", w.writerStatus)
 	}
 
-	for k, v := range h {
-		_, err := io.WriteString(w, fmt.Sprintf("%s: %s\r\n", k, v))
+	if w.header.Len() > 0 {
+		merged := h.Clone()
+		for k, v := range w.header.All() {
+			if _, ok := h.Get(k); !ok {
+				merged.Add(k, v)
+			}
+		}
+		h = merged
+	}
+
+	framing, contentLength, err := w.framingFor(h)
+	if err != nil {
+		return err
+	}
+
+	compress := w.compressible(h, framing, contentLength)
+	if compress {
+		h = compressedHeaders(h, w.encoding)
+	}
+
+	if connection, _ := h.Get("connection"); strings.EqualFold(connection, "close") {
+		w.keepAlive = false
+	}
+	unchunked := (framing == framingChunked || compress) && w.version == "1.0"
+	if (framing == framingClose && !compress) || unchunked {
+		w.keepAlive = false
+	}
+
+	// connection management is left to the final response
+	final := w.statusCode >= 200
+
+	for k, v := range h.All() {
+		if final && !w.keepAlive && strings.EqualFold(k, "connection") {
+			continue
+		}
+		if unchunked && (strings.EqualFold(k, "transfer-encoding") || strings.EqualFold(k, "trailer")) {
+			continue
+		}
+		_, err := io.WriteString(w.conn, fmt.Sprintf("%s: %s\r\n", k, v))
 		if err != nil {
 			return err
 		}
 	}
-	_, err := io.WriteString(w, "\r\n")
+	if final && !w.keepAlive {
+		if _, err := io.WriteString(w.conn, "connection: close\r\n"); err != nil {
+			return err
+		}
+	} else if _, ok := h.Get("connection"); final && !ok && w.version == "1.0" {
+		// HTTP/1.0 connections close by default
+		if _, err := io.WriteString(w.conn, "connection: keep-alive\r\n"); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w.conn, "\r\n")
+	if err != nil {
+		return err
+	}
 
-	if err == nil {
+	w.framing = framing
+	w.remaining = contentLength
+	w.unchunked = unchunked
+	if compress {
+		w.enc = newEncoder(w.encoding, chunkWriter{w})
+	}
+
+	switch {
+	case w.statusCode < 200 && w.statusCode != StatusSwitchingProtocols:
+		// an interim response is followed by another status line
+		w.writerStatus = writerInit
+		w.statusCode = 0
+	case framing == framingNone || (framing == framingContentLength && contentLength == 0):
+		w.writerStatus = writerDone
+	default:
 		w.writerStatus = writerBody
 	}
 
-	return err
+	return nil
+}
+
+// framingFor works out how the body delimited by h ends.
+func (w *Writer) framingFor(h *headers.Headers) (framing, int, error) {
+	if w.head || w.bodyless() {
+		return framingNone, 0, nil
+	}
+
+	if isChunked(h) {
+		if _, ok := h.Get("content-length"); ok {
+			return 0, 0, errors.New("both transfer-encoding and content-length set")
+		}
+		return framingChunked, 0, nil
+	}
+
+	contentLengthStr, ok := h.Get("content-length")
+	if !ok {
+		return framingClose, 0, nil
+	}
+	contentLength, err := strconv.Atoi(contentLengthStr)
+	if err != nil || contentLength < 0 {
+		return 0, 0, fmt.Errorf("invalid content-length: %q", contentLengthStr)
+	}
+
+	return framingContentLength, contentLength, nil
+}
+
+// bodyless reports whether the status code rules out a body (RFC 9110
+// section 6.4.1).
+func (w *Writer) bodyless() bool {
+	return w.statusCode < 200 || w.statusCode == StatusNoContent || w.statusCode == StatusNotModified
+}
+
+func isChunked(h *headers.Headers) bool {
+	te, _ := h.Get("transfer-encoding")
+	return strings.Contains(strings.ToLower(te), "chunked")
 }
 
+// Write writes p as body bytes using the framing committed to by the headers,
+// so handlers can treat the Writer as an io.Writer whatever the framing.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.discardBody() {
+		return len(p), nil
+	}
+	if w.framing == framingChunked && w.writerStatus == writerBody {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if _, err := w.WriteChunkedBody(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return w.WriteBody(p)
+}
+
+// WriteBody writes body bytes for a content-length or close-delimited
+// response. It can be called several times; a content-length response is
+// complete once exactly that many bytes were written.
 func (w *Writer) WriteBody(body []byte) (int, error) {
-	if w.writerStatus != writerBody {
-		return 0, fmt.Errorf("invalid writer status: %v", w.writerStatus)
+	if w.discardBody() {
+		return len(body), nil
+	}
+	if len(body) == 0 && w.writerStatus == writerDone && !w.aborted {
+		return 0, nil
+	}
+	if err := w.checkBody(); err != nil {
+		return 0, err
 	}
 
-	n, err := w.Write(body)
+	switch w.framing {
+	case framingChunked:
+		return 0, ErrChunked
+	case framingContentLength:
+		if len(body) > w.remaining {
+			return 0, ErrBodyTooLong
+		}
+	}
 
-	if err == nil {
-		w.writerStatus = writerDone
+	var n int
+	var err error
+	if w.enc != nil {
+		n, err = w.enc.Write(body)
+	} else {
+		n, err = w.conn.Write(body)
+	}
+	if w.framing == framingContentLength {
+		w.remaining -= n
+		if w.remaining == 0 {
+			w.writerStatus = writerDone
+			if err == nil && w.enc != nil {
+				err = w.endCompressed()
+			}
+		}
 	}
 
 	return n, err
 }
 
+// discardBody reports whether body writes are dropped because the response
+// answers a HEAD request.
+func (w *Writer) discardBody() bool {
+	return w.head && w.writerStatus == writerDone && !w.aborted
+}
+
+// checkBody returns an error if the writer is not ready for body bytes.
+func (w *Writer) checkBody() error {
+	if w.aborted {
+		return ErrAborted
+	}
+	if w.writerStatus == writerDone && w.framing == framingNone {
+		return ErrBodyNotAllowed
+	}
+	if w.writerStatus == writerDone && w.framing == framingContentLength {
+		return ErrBodyTooLong
+	}
+	if w.writerStatus != writerBody {
+		return fmt.Errorf("invalid writer status: %v", w.writerStatus)
+	}
+	return nil
+}
+
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.discardBody() {
+		return len(p), nil
+	}
+	if err := w.checkBody(); err != nil {
+		return 0, err
+	}
+	if w.framing != framingChunked {
+		return 0, ErrNotChunked
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.writeChunk(p)
+}
+
+// writeChunk writes p as one chunk of the body, or as is to an HTTP/1.0
+// client. It returns the number of bytes written to the connection.
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	if len(p) == 0 {
+		// a zero-size chunk would end the body
+		return 0, nil
+	}
+	if w.unchunked {
+		return w.conn.Write(p)
+	}
+
 	hexStr := fmt.Sprintf("%x\r\n", len(p))
-	hex, err := w.Write([]byte(hexStr))
+	hex, err := w.conn.Write([]byte(hexStr))
 	if err != nil {
 		return hex, err
 	}
-	body, err := w.Write(p)
+	body, err := w.conn.Write(p)
 	if err != nil {
 		return body, err
 	}
-	_, err = fmt.Fprint(w, "\r\n")
+	_, err = fmt.Fprint(w.conn, "\r\n")
 	return hex + body + 2, err
 }
 
+// WriteChunkedBodyDone writes the last chunk. Trailers may follow with
+// WriteTrailers; otherwise Finish ends the message.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	writed, err := fmt.Fprint(w, "0\r\n")
+	if w.discardBody() {
+		return 0, nil
+	}
+	if err := w.checkBody(); err != nil {
+		return 0, err
+	}
+	if w.framing != framingChunked {
+		return 0, ErrNotChunked
+	}
+	if w.enc != nil {
+		if err := w.endCompressed(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.unchunked {
+		w.writerStatus = writerTrailers
+		return 0, nil
+	}
+
+	writed, err := fmt.Fprint(w.conn, "0\r\n")
+	if err == nil {
+		w.writerStatus = writerTrailers
+	}
 	return writed, err
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
-	for k, v := range h {
-		_, err := io.WriteString(w, fmt.Sprintf("%s: %s\r\n", k, v))
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	if w.aborted {
+		return ErrAborted
+	}
+	if w.discardBody() {
+		return nil
+	}
+	if w.writerStatus != writerTrailers {
+		return fmt.Errorf("invalid writer status: %v", w.writerStatus)
+	}
+	if w.unchunked {
+		// trailers cannot be sent without chunking
+		w.writerStatus = writerDone
+		return nil
+	}
+
+	for k, v := range h.All() {
+		_, err := io.WriteString(w.conn, fmt.Sprintf("%s: %s\r\n", k, v))
 		if err != nil {
 			return err
 		}
 	}
-	_, err := io.WriteString(w, "\r\n")
+	_, err := io.WriteString(w.conn, "\r\n")
+	if err == nil {
+		w.writerStatus = writerDone
+	}
 
 	return err
 }
+
+// Abort gives up on the response. Further writes fail and Finish reports an
+// error, so the server closes the connection instead of letting the client
+// take a truncated response for a complete one.
+func (w *Writer) Abort() {
+	w.aborted = true
+	w.keepAlive = false
+}
+
+// Finish completes the response once the handler is done with it. Missing
+// parts are filled in: an untouched response becomes an empty 200, a chunked
+// body gets its last chunk and the end of the trailers.
+//
+// It returns an error if the response cannot be completed, because it was
+// aborted or a content-length body is short. The connection must then be
+// closed.
+func (w *Writer) Finish() error {
+	if w.aborted {
+		return ErrAborted
+	}
+
+	switch w.writerStatus {
+	case writerInit:
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+		fallthrough
+	case writerHeaders:
+		if w.bodyless() {
+			// no content-length or content-type for a status without content
+			return w.WriteHeaders(headers.NewHeaders())
+		}
+		return w.WriteHeaders(GetDefaultHeaders(0))
+	case writerBody:
+		switch w.framing {
+		case framingContentLength:
+			w.Abort()
+			return ErrBodyIncomplete
+		case framingChunked:
+			if _, err := w.WriteChunkedBodyDone(); err != nil {
+				return err
+			}
+			return w.WriteTrailers(nil)
+		case framingClose:
+			w.writerStatus = writerDone
+			if w.enc != nil {
+				return w.endCompressed()
+			}
+		}
+	case writerTrailers:
+		return w.WriteTrailers(nil)
+	}
+
+	return nil
+}
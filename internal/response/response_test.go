@@ -0,0 +1,474 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/headers"
+)
+
+// fields builds headers from name, value pairs.
+func TestWriteStatusLine(t *testing.T) {
+	// Test: Registered status code
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", buf.String())
+	assert.Equal(t, StatusNotFound, w.StatusCode())
+
+	// Test: Unregistered status code keeps the trailing space
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(299))
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+
+	// Test: Custom reason phrase
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLineReason(StatusOK, "All Good"))
+	assert.Equal(t, "HTTP/1.1 200 All Good\r\n", buf.String())
+
+	// Test: Reason phrase cannot inject headers
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.Error(t, w.WriteStatusLineReason(StatusOK, "OK\r\nSet-Cookie: x"))
+	assert.Empty(t, buf.String())
+
+	// Test: Status code out of range
+	require.Error(t, w.WriteStatusLine(42))
+
+	// Test: Status line can only be written once
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.Error(t, w.WriteStatusLine(StatusOK))
+}
+
+func TestWriterFraming(t *testing.T) {
+	// Test: Content-length body written in several parts
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-length", "10")))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 10\r\n\r\nhelloworld", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Writing past content-length fails without writing
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-length", "3")))
+	_, err = w.WriteBody([]byte("hello"))
+	require.ErrorIs(t, err, ErrBodyTooLong)
+	assert.NotContains(t, buf.String(), "hello")
+
+	// Test: Short content-length body aborts the response
+	_, err = w.WriteBody([]byte("he"))
+	require.NoError(t, err)
+	require.ErrorIs(t, w.Finish(), ErrBodyIncomplete)
+	assert.False(t, w.KeepAlive())
+
+	// Test: HEAD response keeps content-length and discards the body
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	w.SetHead(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-length", "5")))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Finish completes a status without content with no default headers
+	for _, code := range []StatusCode{StatusNoContent, StatusNotModified} {
+		buf.Reset()
+		w = NewWriter(&buf)
+		w.SetKeepAlive(true)
+		require.NoError(t, w.WriteStatusLine(code))
+		require.NoError(t, w.Finish())
+		assert.Equal(t, fmt.Sprintf("HTTP/1.1 %d %s\r\n\r\n", code, StatusText(code)), buf.String())
+		assert.True(t, w.KeepAlive())
+	}
+
+	// Test: Finish completes an empty response with default headers
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-length: 0\r\n")
+
+	// Test: Chunked writes require chunked framing
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-length", "5")))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.ErrorIs(t, err, ErrNotChunked)
+
+	// Test: Fixed writes are rejected under chunked framing, and Finish ends the body
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("transfer-encoding", "chunked")))
+	_, err = w.WriteBody([]byte("hello"))
+	require.ErrorIs(t, err, ErrChunked)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Trailers after the last chunk
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("transfer-encoding", "chunked")))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders("x-sum", "abc")))
+	require.NoError(t, w.Finish())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n0\r\nx-sum: abc\r\n\r\n")))
+
+	// Test: No length and no chunking means a close-delimited body
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.False(t, w.KeepAlive())
+	assert.Contains(t, buf.String(), "connection: close\r\n")
+
+	// Test: Statuses without a body reject body writes
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err = w.WriteBody([]byte("hello"))
+	require.ErrorIs(t, err, ErrBodyNotAllowed)
+	require.NoError(t, w.Finish())
+
+	// Test: Interim response is followed by the final one
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusEarlyHints))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("link", "</style.css>; rel=preload")))
+	assert.Zero(t, w.StatusCode())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	// Test: Untouched response becomes an empty 200
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.Finish())
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("HTTP/1.1 200 OK\r\n")))
+	assert.Contains(t, buf.String(), "content-length: 0\r\n")
+}
+
+func TestWriterHTTP10(t *testing.T) {
+	// Test: Keep-alive has to be announced to HTTP/1.0 clients
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("1.0")
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-length", "2")))
+	_, err := w.Write([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.0 200 OK\r\ncontent-length: 2\r\nconnection: keep-alive\r\n\r\nok", buf.String())
+
+	// Test: Chunked body is sent unchunked and close-delimited
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetVersion("1.0")
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("transfer-encoding", "chunked", "trailer", "x-sum")))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders("x-sum", "abc")))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nconnection: close\r\n\r\nhello", buf.String())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestWriteHeadersOrder(t *testing.T) {
+	// Test: Fields are written in order, with their casing and every value
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	w.Header().Set("X-Request-Id", "abc")
+	w.Header().Set("Content-Type", "text/plain")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders(
+		"Content-Length", "0",
+		"Set-Cookie", "a=1",
+		"Content-Type", "text/html",
+		"Set-Cookie", "b=2",
+	)))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 0\r\n"+
+		"Set-Cookie: a=1\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"X-Request-Id: abc\r\n"+
+		"\r\n", buf.String())
+}
+
+// dechunk splits a response with a chunked body into its head, decoded body
+// and trailer section.
+func dechunk(t *testing.T, res string) (string, []byte, string) {
+	t.Helper()
+	head, rest, ok := strings.Cut(res, "\r\n\r\n")
+	require.True(t, ok)
+
+	var body []byte
+	for {
+		line, after, ok := strings.Cut(rest, "\r\n")
+		require.True(t, ok)
+		size, err := strconv.ParseUint(line, 16, 32)
+		require.NoError(t, err)
+		if size == 0 {
+			return head, body, after
+		}
+		body = append(body, after[:size]...)
+		rest = strings.TrimPrefix(after[size:], "\r\n")
+	}
+}
+
+func TestWriterCompression(t *testing.T) {
+	payload := []byte(strings.Repeat(`{"name":"value"},`, 100))
+
+	// Test: Content-length body goes out gzipped and chunked
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.EnableCompression("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-type", "application/json", "content-length", strconv.Itoa(len(payload)), "etag", `"v1"`)))
+	_, err := w.WriteBody(payload[:100])
+	require.NoError(t, err)
+	_, err = w.Write(payload[100:])
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+
+	head, body, trailers := dechunk(t, buf.String())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-type: application/json\r\netag: W/\"v1\"\r\ntransfer-encoding: chunked\r\ncontent-encoding: gzip\r\nvary: accept-encoding", head)
+	assert.Equal(t, "\r\n", trailers)
+	assert.Less(t, len(body), len(payload))
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	// Test: Writing past the declared content-length still fails
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.EnableCompression("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-length", strconv.Itoa(len(payload)))))
+	_, err = w.WriteBody(append(payload, 'x'))
+	assert.ErrorIs(t, err, ErrBodyTooLong)
+
+	// Test: Chunked body is deflated and keeps its trailers
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.EnableCompression("deflate"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("transfer-encoding", "chunked", "trailer", "x-sum")))
+	_, err = w.WriteChunkedBody(payload)
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders("x-sum", "abc")))
+
+	head, body, trailers = dechunk(t, buf.String())
+	assert.Contains(t, head, "content-encoding: deflate\r\n")
+	assert.Equal(t, "x-sum: abc\r\n\r\n", trailers)
+	zr2, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr2)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	// Test: Close-delimited body is compressed and keeps the connection alive
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.EnableCompression("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-type", "text/plain")))
+	_, err = w.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	_, _, trailers = dechunk(t, buf.String())
+	assert.Equal(t, "\r\n", trailers)
+
+	// Test: Small, already compressed and partial bodies are left alone
+	for _, h := range []*headers.Headers{
+		headers.NewHeaders("content-length", "5"),
+		headers.NewHeaders("content-type", "image/png", "content-length", strconv.Itoa(len(payload))),
+		headers.NewHeaders("content-encoding", "br", "content-length", strconv.Itoa(len(payload))),
+	} {
+		buf.Reset()
+		w = NewWriter(&buf)
+		require.NoError(t, w.EnableCompression("gzip"))
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+		assert.NotContains(t, buf.String(), "content-encoding: gzip")
+		assert.Contains(t, buf.String(), "content-length: ")
+	}
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.EnableCompression("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusPartialContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-length", strconv.Itoa(len(payload)))))
+	assert.NotContains(t, buf.String(), "content-encoding")
+
+	// Test: HTTP/1.0 gets the compressed body close-delimited
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetVersion("1.0")
+	w.SetKeepAlive(true)
+	require.NoError(t, w.EnableCompression("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("content-length", strconv.Itoa(len(payload)))))
+	_, err = w.WriteBody(payload)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+	head, rest, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	assert.Equal(t, "HTTP/1.0 200 OK\r\ncontent-encoding: gzip\r\nvary: accept-encoding\r\nconnection: close", head)
+	zr, err = gzip.NewReader(strings.NewReader(rest))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	// Test: Unsupported encoding is refused
+	assert.Error(t, NewWriter(&buf).EnableCompression("br"))
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-length response written by Writer round-trips
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("Content-Type", "text/plain", "content-length", "5", "x-multi", "a", "x-multi", "b")))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+
+	res, err := ResponseFromReader(iotest.OneByteReader(&buf), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusLine{HttpVersion: "1.1", StatusCode: StatusOK, Reason: "OK"}, res.StatusLine)
+	assert.Equal(t, []string{"a", "b"}, res.Headers.Values("x-multi"))
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.False(t, res.CloseDelimited())
+
+	// Test: Chunked response with trailers
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders("transfer-encoding", "chunked", "trailer", "x-sum")))
+	_, err = w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders("x-sum", "abc")))
+
+	res, err = ResponseFromReader(iotest.OneByteReader(&buf), "GET")
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, []string{"abc"}, res.Trailers.Values("x-sum"))
+
+	// Test: Close-delimited response reads to EOF
+	res, err = ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\ncontent-type: text/plain\r\n\r\nuntil the end"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", res.StatusLine.HttpVersion)
+	assert.True(t, res.CloseDelimited())
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(body))
+
+	// Test: Responses without a body by definition
+	for _, tc := range []struct{ method, raw string }{
+		{"HEAD", "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\n"},
+		{"GET", "HTTP/1.1 204 No Content\r\n\r\n"},
+		{"GET", "HTTP/1.1 304 Not Modified\r\ncontent-length: 5\r\n\r\n"},
+	} {
+		res, err = ResponseFromReader(strings.NewReader(tc.raw+"HTTP/1.1 200 OK\r\n"), tc.method)
+		require.NoError(t, err)
+		body, err = io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Empty(t, body, tc.raw)
+	}
+
+	// Test: Interim responses are skipped
+	res, err = ResponseFromReader(strings.NewReader("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nlink: </style.css>\r\n\r\nHTTP/1.1 201 Created\r\ncontent-length: 2\r\n\r\nok"), "POST")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCreated, res.StatusLine.StatusCode)
+	assert.Equal(t, 1, res.Headers.Len())
+
+	// Test: Successive responses on one connection, with an unread body
+	rr := NewReader(strings.NewReader("HTTP/1.1 200 OK\r\ncontent-length: 3\r\n\r\none" +
+		"HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n3\r\ntwo\r\n0\r\n\r\n" +
+		"HTTP/1.1 404 Not Found\r\ncontent-length: 5\r\n\r\nthree"))
+	res, err = rr.ReadResponse("GET")
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	res, err = rr.ReadResponse("GET")
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "two", string(body))
+	res, err = rr.ReadResponse("GET")
+	require.NoError(t, err)
+	assert.Equal(t, "Not Found", res.StatusLine.Reason)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "three", string(body))
+	_, err = rr.ReadResponse("GET")
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Truncated and malformed responses
+	res, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\ncontent-length: 10\r\n\r\nshort"), "GET")
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\ncontent"), "GET")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/2 200 OK\r\n\r\n"), "GET")
+	assert.Error(t, err)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 2000 OK\r\n\r\n"), "GET")
+	assert.Error(t, err)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\ncontent-length: -1\r\n\r\n"), "GET")
+	assert.Error(t, err)
+}
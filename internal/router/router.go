@@ -0,0 +1,236 @@
+package router
+
+import (
+	"slices"
+	"strings"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+type segmentKind int
+
+const (
+	segmentStatic segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests to handlers registered by method and path
+// pattern. Requests that match no pattern get a 404, and requests that match
+// a pattern registered for other methods get a 405 with an Allow header. GET
+// patterns also match HEAD requests.
+type Router struct {
+	routes []route
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for pattern, which is an optional method followed
+// by a path: "GET /users/{id}" or "/health". A pattern without a method
+// matches every method.
+//
+// Path segments written as {name} match any single segment and are exposed
+// through request.Request.Param. A final "*" segment matches the rest of the
+// path, including nothing, and is exposed as the "*" parameter.
+func (rt *Router) Handle(pattern string, handler server.Handler) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	path = strings.TrimSpace(path)
+
+	if !strings.HasPrefix(path, "/") {
+		panic("router: pattern path must start with /: " + pattern)
+	}
+
+	var segments []segment
+	parts := strings.Split(path[1:], "/")
+	for i, part := range parts {
+		switch {
+		case part == "*":
+			if i != len(parts)-1 {
+				panic("router: wildcard must be the last segment: " + pattern)
+			}
+			segments = append(segments, segment{kind: segmentWildcard, value: "*"})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			segments = append(segments, segment{kind: segmentParam, value: part[1 : len(part)-1]})
+		default:
+			segments = append(segments, segment{kind: segmentStatic, value: part})
+		}
+	}
+
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: segments,
+		handler:  handler,
+	})
+}
+
+// Mount sends every request under prefix, whatever its method, to handler
+// with the prefix stripped from the request target.
+func (rt *Router) Mount(prefix string, handler server.Handler) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	rt.Handle(prefix+"/*", StripPrefix(prefix, handler))
+}
+
+// StripPrefix returns a handler that removes prefix from the request path
+// before calling handler, and puts it back once handler returns so that
+// middleware around the router still sees the target the client sent.
+func StripPrefix(prefix string, handler server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		// req is changed in place rather than copied: its body sets
+		// Trailers on the request it was read for
+		target, requestTarget := req.Target, req.RequestLine.RequestTarget
+		defer func() {
+			req.Target, req.RequestLine.RequestTarget = target, requestTarget
+		}()
+
+		req.Target.Path = stripPrefix(req.Target.Path, prefix)
+		req.Target.RawPath = stripPrefix(req.Target.RawPath, prefix)
+
+		stripped := req.Target.RawPath
+		if req.Target.RawQuery != "" {
+			stripped += "?" + req.Target.RawQuery
+		}
+		req.RequestLine.RequestTarget = stripped
+
+		handler(w, req)
+	}
+}
+
+func stripPrefix(path, prefix string) string {
+	path = strings.TrimPrefix(path, prefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// Serve is the server.Handler that dispatches req to the matching route.
+func (rt *Router) Serve(w *response.Writer, req *request.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.Target.Path, "/"), "/")
+
+	var best *route
+	var bestParams map[string]string
+	var allowed []string
+
+	for i := range rt.routes {
+		r := &rt.routes[i]
+		params, ok := r.match(parts)
+		if !ok {
+			continue
+		}
+		if !r.allows(req.RequestLine.Method) {
+			allowed = append(allowed, r.method)
+			if r.method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+			continue
+		}
+		if best == nil || r.moreSpecific(best, req.RequestLine.Method) {
+			best, bestParams = r, params
+		}
+	}
+
+	if best != nil {
+		req.Params = bestParams
+		best.handler(w, req)
+		return
+	}
+
+	if len(allowed) > 0 {
+		slices.Sort(allowed)
+		res := []byte("Method Not Allowed")
+		h := response.GetDefaultHeaders(len(res))
+		h.Set("allow", strings.Join(slices.Compact(allowed), ", "))
+		w.WriteStatusLine(response.StatusMethodNotAllowed)
+		w.WriteHeaders(h)
+		w.WriteBody(res)
+		return
+	}
+
+	res := []byte("Not Found")
+	w.WriteStatusLine(response.StatusNotFound)
+	w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+	w.WriteBody(res)
+}
+
+func (r *route) match(parts []string) (map[string]string, bool) {
+	params := map[string]string{}
+
+	for i, seg := range r.segments {
+		if seg.kind == segmentWildcard {
+			params["*"] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentStatic:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg.value] = parts[i]
+		}
+	}
+
+	if len(parts) != len(r.segments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// allows reports whether r serves requests with method. GET routes also serve
+// HEAD, which the response Writer answers without the body.
+func (r *route) allows(method string) bool {
+	return r.method == "" || r.method == method || r.method == "GET" && method == "HEAD"
+}
+
+// moreSpecific reports whether r should win over other when both match the
+// same path for a request with method: static segments beat parameters, which
+// beat wildcards, and a route bound to the method beats a GET route serving
+// HEAD, which beats one that accepts any method.
+func (r *route) moreSpecific(other *route, method string) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+	if len(r.segments) != len(other.segments) {
+		// the longer pattern can only match the same path through a trailing
+		// wildcard matching nothing
+		return len(r.segments) < len(other.segments)
+	}
+	return r.methodRank(method) > other.methodRank(method)
+}
+
+func (r *route) methodRank(method string) int {
+	switch r.method {
+	case method:
+		return 2
+	case "":
+		return 0
+	}
+	return 1
+}
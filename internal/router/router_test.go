@@ -0,0 +1,106 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// named returns a handler that writes its name and the captured parameters.
+func named(name string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		res := []byte(name + " " + req.Param("id") + " " + req.Param("*") + " " + req.RequestLine.RequestTarget)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+		w.WriteBody(res)
+	}
+}
+
+func serve(t *testing.T, rt *Router, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	rt.Serve(response.NewWriter(&buf), req)
+	return buf.String()
+}
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("user"))
+	rt.Handle("DELETE /users/{id}", named("delete"))
+	rt.Handle("GET /users/me", named("me"))
+	rt.Handle("/static/*", named("static"))
+	rt.Handle("GET /", named("root"))
+	rt.Mount("/api", named("api"))
+
+	// Test: Path parameter is captured
+	res := serve(t, rt, "GET /users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(res, "user 42  /users/42"))
+
+	// Test: Static segment wins over a parameter
+	res = serve(t, rt, "GET /users/me HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "me   /users/me"))
+
+	// Test: Method selects between routes with the same pattern
+	res = serve(t, rt, "DELETE /users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "delete 42  /users/42"))
+
+	// Test: Query string is ignored for matching
+	res = serve(t, rt, "GET /users/42?full=true HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "user 42  /users/42?full=true"))
+
+	// Test: Wildcard captures the rest of the path for any method
+	res = serve(t, rt, "POST /static/css/site.css HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "static  css/site.css /static/css/site.css"))
+
+	// Test: Mount strips its prefix
+	res = serve(t, rt, "GET /api/v1/items HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "api  v1/items /v1/items"))
+	res = serve(t, rt, "GET /api HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "api   /"))
+
+	// Test: Mount puts the prefix back once its handler returns
+	req, err := request.RequestFromReader(strings.NewReader("GET /api/v1/items?x=1 HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	rt.Serve(response.NewWriter(io.Discard), req)
+	assert.Equal(t, "/api/v1/items?x=1", req.RequestLine.RequestTarget)
+	assert.Equal(t, "/api/v1/items", req.Target.Path)
+	assert.Equal(t, "/api/v1/items", req.Target.RawPath)
+
+	// Test: Unknown path is a 404
+	res = serve(t, rt, "GET /nope HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Known path with another method is a 405 listing allowed methods
+	res = serve(t, rt, "PUT /users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, res, "allow: DELETE, GET, HEAD\r\n")
+
+	// Test: GET route serves HEAD without the body
+	req, err = request.RequestFromReader(strings.NewReader("HEAD /users/42 HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetHead(true)
+	rt.Serve(w, req)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "content-length: 18\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+
+	// Test: HEAD route wins over the GET one
+	rt.Handle("HEAD /users/{id}", named("head"))
+	res = serve(t, rt, "HEAD /users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "head 42  /users/42"))
+	res = serve(t, rt, "GET /users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "user 42  /users/42"))
+}
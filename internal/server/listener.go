@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation, after stdin, stdout and stderr.
+const listenFDsStart = 3
+
+// SystemdListeners returns the listeners passed to the process by systemd
+// socket activation, in the order of the socket unit, or none if the process
+// was not socket-activated. The environment variables describing them are
+// unset so child processes do not inherit them.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		ln, err := FileListener(uintptr(fd))
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
+
+// FileListener returns a listener for the listening socket open as file
+// descriptor fd, such as one inherited from a parent process.
+func FileListener(fd uintptr) (net.Listener, error) {
+	f := os.NewFile(fd, "listener-"+strconv.Itoa(int(fd)))
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	// net.FileListener duplicates the descriptor
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("file descriptor %d is not a listening socket: %w", fd, err)
+	}
+	return ln, nil
+}
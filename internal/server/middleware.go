@@ -0,0 +1,13 @@
+package server
+
+// Middleware wraps a Handler with behavior that runs around it.
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middlewares. The first middleware is the outermost
+// one, so it sees the request first and the response last.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
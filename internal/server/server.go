@@ -1,74 +1,348 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 )
 
+const (
+	// DefaultReadHeaderTimeout is how long a client has to send the request
+	// line and headers.
+	DefaultReadHeaderTimeout = 10 * time.Second
+	// DefaultIdleTimeout is how long a keep-alive connection may wait for its
+	// next request before the server closes it.
+	DefaultIdleTimeout = 2 * time.Minute
+)
+
 type Handler func(w *response.Writer, req *request.Request)
 
+// Server serves requests to Handler. Its configuration must be set before
+// Start and left alone afterwards.
 type Server struct {
+	// Addr is the address the server is bound to once started.
 	Addr    string
 	Running atomic.Bool
 	Handler Handler
-	ln      net.Listener
+	// ReadHeaderTimeout bounds the time to read the request line and headers,
+	// from the first byte of the request. A client too slow gets a 408.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds the time the handler has to read the body, from the
+	// end of the headers.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time to write the response, from the end of the
+	// headers.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds the time a keep-alive connection waits for the next
+	// request.
+	IdleTimeout time.Duration
+	// Limits bounds the size of the requests accepted.
+	Limits request.Limits
+	// TLSConfig configures StartTLS. It is cloned, so it can be shared between
+	// servers; the certificate given to StartTLS is added to it and
+	// "http/1.1" is advertised through ALPN.
+	TLSConfig *tls.Config
+	ln        net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]connState
+}
+
+// New returns a server for handler with the default timeouts and limits. A
+// zero timeout means no timeout.
+func New(handler Handler) *Server {
+	return &Server{
+		Handler:           handler,
+		ReadHeaderTimeout: DefaultReadHeaderTimeout,
+		IdleTimeout:       DefaultIdleTimeout,
+		Limits:            request.DefaultLimits,
+	}
 }
 
+// Serve starts a server with the default configuration on localhost:port.
 func Serve(port int, handler Handler) (*Server, error) {
-	ln, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
-	if err != nil {
+	return ServeAddr("tcp", fmt.Sprintf("localhost:%d", port), handler)
+}
+
+// ServeAddr starts a server with the default configuration listening on
+// address, as understood by net.Listen for network: "tcp" with ":8080" binds
+// every interface, "unix" with a path binds a Unix domain socket.
+func ServeAddr(network, address string, handler Handler) (*Server, error) {
+	server := New(handler)
+	if err := server.StartAddr(network, address); err != nil {
 		return nil, err
 	}
+	return server, nil
+}
 
-	server := &Server{
-		Addr:    ln.Addr().String(),
-		Running: atomic.Bool{},
-		Handler: handler,
-		ln:      ln,
+// ServeListener starts a server with the default configuration accepting
+// connections from ln, which it closes when the server stops.
+func ServeListener(ln net.Listener, handler Handler) (*Server, error) {
+	server := New(handler)
+	if err := server.StartListener(ln); err != nil {
+		return nil, err
 	}
-	server.Running.Store(true)
+	return server, nil
+}
+
+// Start listens on localhost:port and serves connections in the background.
+func (s *Server) Start(port int) error {
+	return s.StartAddr("tcp", fmt.Sprintf("localhost:%d", port))
+}
 
-	go server.listen()
+// StartAddr listens on address, as understood by net.Listen for network, and
+// serves connections in the background. Addr reports the address actually
+// bound, which tells the port picked for ":0".
+func (s *Server) StartAddr(network, address string) error {
+	if s.ln != nil {
+		return errors.New("server already started")
+	}
 
-	return server, nil
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+
+	return s.StartListener(ln)
+}
+
+// StartListener serves connections accepted from ln in the background. The
+// listener is owned by the server from then on and closed when it stops.
+func (s *Server) StartListener(ln net.Listener) error {
+	if s.ln != nil {
+		return errors.New("server already started")
+	}
+
+	s.Addr = ln.Addr().String()
+	s.ln = ln
+	s.Running.Store(true)
+
+	go s.listen()
+
+	return nil
+}
+
+// ListenAddr returns the address the server is bound to, or nil if it has not
+// been started.
+func (s *Server) ListenAddr() net.Addr {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
 }
 
 func (s *Server) handle(conn net.Conn) {
+	defer s.untrackConn(conn)
 	defer conn.Close()
 
-	writer := response.NewWriter(conn)
+	reader := request.NewReader(conn)
+	reader.Limits = s.Limits
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		writer.WriteStatusLine(response.StatusBadRequest)
-		writer.WriteHeaders(response.GetDefaultHeaders(len(err.Error())))
-		writer.WriteBody([]byte(err.Error()))
-		return
+	// a new connection is expected to send its request right away
+	waitTimeout := s.ReadHeaderTimeout
+	for {
+		setReadDeadline(conn, waitTimeout)
+		if err := reader.WaitForRequest(); err != nil {
+			return
+		}
+		// from its first byte on, the request is let finish by Shutdown
+		if !s.setConnState(conn, connActive) {
+			return
+		}
+		waitTimeout = s.IdleTimeout
+
+		setReadDeadline(conn, s.ReadHeaderTimeout)
+		req, err := reader.ReadRequest()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			writer := response.NewWriter(conn)
+			setWriteDeadline(conn, s.WriteTimeout)
+			writeError(writer, err)
+			writer.Finish()
+			return
+		}
+
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			req.TLS = &state
+		}
+
+		setReadDeadline(conn, s.ReadTimeout)
+		setWriteDeadline(conn, s.WriteTimeout)
+
+		writer := response.NewWriter(conn)
+		writer.SetVersion(req.RequestLine.HttpVersion)
+		writer.SetHead(req.RequestLine.Method == "HEAD")
+		writer.SetKeepAlive(keepAlive(req) && s.Running.Load())
+
+		switch expect := req.Expect(); expect {
+		case "", "100-continue":
+			if req.ExpectsContinue() {
+				// a final response sent before the body was asked for closes
+				// the connection, as the client may still send the body
+				keep := writer.KeepAlive()
+				writer.SetKeepAlive(false)
+				req.SetContinue(func() error {
+					if writer.StatusCode() == 0 {
+						writer.SetKeepAlive(keep)
+					}
+					return writeContinue(writer)
+				})
+			}
+			s.Handler(writer, req)
+		default:
+			res := []byte("Expectation Failed")
+			writer.WriteStatusLine(response.StatusExpectationFailed)
+			writer.WriteHeaders(response.GetDefaultHeaders(len(res)))
+			writer.WriteBody(res)
+		}
+
+		// whatever the handler left unread has to be consumed before the next
+		// request can be parsed
+		bodyErr := req.Body.Close()
+		if bodyErr != nil {
+			writer.SetKeepAlive(false)
+		}
+		if bodyErr != nil && writer.StatusCode() == 0 && !errors.Is(bodyErr, request.ErrBodyNotSent) {
+			// the handler gave up on a body that timed out or was too large
+			writeError(writer, bodyErr)
+		}
+
+		// a response the handler left unfinished is completed, or the
+		// connection is dropped if that is not possible
+		if err := writer.Finish(); err != nil {
+			return
+		}
+		if bodyErr != nil || !writer.KeepAlive() {
+			return
+		}
+		if !s.setConnState(conn, connIdle) {
+			return
+		}
+	}
+}
+
+// writeContinue sends the 100 Continue a client waits for before sending the
+// body, unless the handler already started its final response.
+func writeContinue(writer *response.Writer) error {
+	if writer.StatusCode() != 0 {
+		return nil
+	}
+	if err := writer.WriteStatusLine(response.StatusContinue); err != nil {
+		return err
 	}
+	return writer.WriteHeaders(headers.NewHeaders())
+}
 
-	s.Handler(writer, req)
+func setReadDeadline(conn net.Conn, timeout time.Duration) {
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+func setWriteDeadline(conn net.Conn, timeout time.Duration) {
+	if timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+	} else {
+		conn.SetWriteDeadline(time.Time{})
+	}
+}
+
+// writeError answers a request that could not be read with the status
+// matching err.
+func writeError(writer *response.Writer, err error) {
+	writer.WriteStatusLine(errorStatus(err))
+	writer.WriteHeaders(response.GetDefaultHeaders(len(err.Error())))
+	writer.WriteBody([]byte(err.Error()))
+}
+
+// errorStatus maps an error from parsing a request to the status code it is
+// answered with.
+func errorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return response.StatusRequestTimeout
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.StatusHTTPVersionNotSupported
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.StatusURITooLong
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusContentTooLarge
+	}
+	return response.StatusBadRequest
+}
+
+// keepAlive reports whether the client allows the connection to be reused
+// after responding to req. HTTP/1.1 connections persist unless the client
+// sends "Connection: close", HTTP/1.0 ones only with "Connection: keep-alive".
+// A close token wins over keep-alive wherever it appears.
+func keepAlive(req *request.Request) bool {
+	keep := req.RequestLine.HttpVersion != "1.0"
+	for _, connection := range req.Headers.Values("connection") {
+		for _, token := range strings.Split(connection, ",") {
+			token = strings.TrimSpace(token)
+			if strings.EqualFold(token, "close") {
+				return false
+			}
+			if strings.EqualFold(token, "keep-alive") {
+				keep = true
+			}
+		}
+	}
+	return keep
 }
 
 func (s *Server) listen() {
 	for {
 		conn, err := s.ln.Accept()
 		if err != nil {
-			if s.Running.Load() {
+			if !s.Running.Load() {
 				return
 			}
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
+		if !s.trackConn(conn) {
+			// accepted while shutting down, too late to be served
+			conn.Close()
+			continue
+		}
 		go s.handle(conn)
 	}
 }
 
+// Close stops the server immediately: the listener and every connection are
+// closed, including those still writing a response. Use Shutdown to let them
+// finish.
 func (s *Server) Close() error {
 	s.Running.Store(false)
-	return s.ln.Close()
+	err := s.ln.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+
+	return err
 }
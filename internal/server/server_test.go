@@ -0,0 +1,461 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+func targetHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// readResponse reads one content-length framed response and returns its status
+// line, lowercased headers and body.
+func readResponse(t *testing.T, r *bufio.Reader) (string, map[string]string, string) {
+	t.Helper()
+
+	statusLine, err := r.ReadString('\n')
+	require.NoError(t, err)
+
+	h := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		k, v, _ := strings.Cut(line, ":")
+		h[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+
+	n, err := strconv.Atoi(h["content-length"])
+	require.NoError(t, err)
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	require.NoError(t, err)
+
+	return strings.TrimRight(statusLine, "\r\n"), h, string(body)
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	server, err := Serve(0, targetHandler)
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// Test: Connection is reused for successive requests
+	_, err = io.WriteString(conn, "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, h, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.NotContains(t, h, "connection")
+	assert.Equal(t, "/first", body)
+
+	// Test: Pipelined requests are answered in order
+	_, err = io.WriteString(conn, "GET /second HTTP/1.1\r\nHost: localhost\r\n\r\nGET /third HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/second", body)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/third", body)
+
+	// Test: A body the handler did not read is discarded before the next request
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhelloGET /after HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/upload", body)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/after", body)
+
+	// Test: close wins over keep-alive in the same header
+	closing, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer closing.Close()
+	closingReader := bufio.NewReader(closing)
+	_, err = io.WriteString(closing, "GET /mixed HTTP/1.1\r\nHost: localhost\r\nConnection: keep-alive, close\r\n\r\nGET /ignored HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, h, body = readResponse(t, closingReader)
+	assert.Equal(t, "close", h["connection"])
+	assert.Equal(t, "/mixed", body)
+	// the unread pipelined request may turn the close into a reset
+	_, err = closingReader.ReadByte()
+	assert.Error(t, err)
+
+	// Test: Connection: close from the client ends the connection
+	_, err = io.WriteString(conn, "GET /last HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	_, h, body = readResponse(t, r)
+	assert.Equal(t, "close", h["connection"])
+	assert.Equal(t, "/last", body)
+
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServerShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		targetHandler(w, req)
+	})
+	require.NoError(t, err)
+
+	idle, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	idleReader := bufio.NewReader(idle)
+	_, err = io.WriteString(idle, "GET /idle HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	readResponse(t, idleReader)
+
+	partial, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer partial.Close()
+	_, err = io.WriteString(partial, "GET /partial HTTP/1.1\r\nHost: localhost\r\n")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return server.activeConns() == 1 }, time.Second, time.Millisecond)
+
+	active, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer active.Close()
+	_, err = io.WriteString(active, "GET /slow HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	done := make(chan error)
+	go func() {
+		done <- server.Shutdown(context.Background())
+	}()
+
+	// Test: Idle keep-alive connections are closed right away
+	_, err = idleReader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Shutdown waits for the in-flight request
+	select {
+	case <-done:
+		t.Fatal("shutdown returned before the active request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test: A request whose headers are still arriving is answered
+	_, err = io.WriteString(partial, "\r\n")
+	require.NoError(t, err)
+	partialReader := bufio.NewReader(partial)
+	status, h, body := readResponse(t, partialReader)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "close", h["connection"])
+	assert.Equal(t, "/partial", body)
+	_, err = partialReader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: The in-flight response completes and closes the connection
+	close(release)
+	activeReader := bufio.NewReader(active)
+	status, _, body = readResponse(t, activeReader)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/slow", body)
+	_, err = activeReader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	require.NoError(t, <-done)
+
+	// Test: New connections are refused
+	_, err = net.Dial("tcp", server.Addr)
+	assert.Error(t, err)
+}
+
+// activeConns returns the number of connections with a request in progress.
+func (s *Server) activeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, state := range s.conns {
+		if state == connActive {
+			n++
+		}
+	}
+	return n
+}
+
+func TestServerShutdownContextExpires(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	server, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Test: The stuck connection was closed
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServerHTTP10(t *testing.T) {
+	server, err := Serve(0, targetHandler)
+	require.NoError(t, err)
+	defer server.Close()
+
+	// Test: HTTP/1.0 connection persists when the client asks for it
+	conn, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	_, err = io.WriteString(conn, "GET /first HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	require.NoError(t, err)
+	status, h, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.0 200 OK", status)
+	assert.Equal(t, "keep-alive", h["connection"])
+	assert.Equal(t, "/first", body)
+
+	// Test: HTTP/1.0 connection closes by default
+	_, err = io.WriteString(conn, "GET /second HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	status, h, body = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.0 200 OK", status)
+	assert.Equal(t, "close", h["connection"])
+	assert.Equal(t, "/second", body)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Unsupported versions get a 505
+	conn2, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer conn2.Close()
+	_, err = io.WriteString(conn2, "GET / HTTP/1.2\r\n\r\n")
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, bufio.NewReader(conn2))
+	assert.Equal(t, "HTTP/1.1 505 HTTP Version Not Supported", status)
+}
+
+func TestServerLimits(t *testing.T) {
+	server, err := Serve(0, targetHandler)
+	require.NoError(t, err)
+	defer server.Close()
+
+	tests := []struct {
+		raw    string
+		status string
+	}{
+		{"GET /" + strings.Repeat("a", 10<<10) + " HTTP/1.1\r\n\r\n", "HTTP/1.1 414 URI Too Long"},
+		{"GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", 70<<10) + "\r\n\r\n", "HTTP/1.1 431 Request Header Fields Too Large"},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", server.Addr)
+		require.NoError(t, err)
+		// the server may answer before reading everything, so don't fail on write
+		go io.WriteString(conn, tt.raw)
+		status, _, _ := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, tt.status, status)
+		conn.Close()
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	server := New(func(w *response.Writer, req *request.Request) {
+		// a handler that cannot read the body leaves the response to the server
+		if _, err := io.ReadAll(req.Body); err != nil {
+			return
+		}
+		targetHandler(w, req)
+	})
+	server.ReadHeaderTimeout = 100 * time.Millisecond
+	server.ReadTimeout = 100 * time.Millisecond
+	server.IdleTimeout = 100 * time.Millisecond
+	require.NoError(t, server.Start(0))
+	defer server.Close()
+
+	// Test: Idle keep-alive connection is closed after IdleTimeout
+	conn, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "GET /idle HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, _, body := readResponse(t, r)
+	assert.Equal(t, "/idle", body)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Headers sent too slowly get a 408
+	conn, err = net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: local")
+	require.NoError(t, err)
+	status, h, _ := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 408 Request Timeout", status)
+	assert.Equal(t, "close", h["connection"])
+
+	// Test: Body sent too slowly gets a 408
+	conn, err = net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "POST /slow HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc")
+	require.NoError(t, err)
+	status, h, _ = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 408 Request Timeout", status)
+	assert.Equal(t, "close", h["connection"])
+}
+
+func TestServerListeners(t *testing.T) {
+	get := func(t *testing.T, network, address string) string {
+		t.Helper()
+		conn, err := net.Dial(network, address)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, "GET /where HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		_, _, body := readResponse(t, bufio.NewReader(conn))
+		return body
+	}
+
+	// Test: Address with an ephemeral port reports the bound port
+	server, err := ServeAddr("tcp", "127.0.0.1:0", targetHandler)
+	require.NoError(t, err)
+	defer server.Close()
+	assert.NotEqual(t, "127.0.0.1:0", server.Addr)
+	assert.Equal(t, server.Addr, server.ListenAddr().String())
+	assert.Equal(t, "/where", get(t, "tcp", server.Addr))
+
+	// Test: Unix domain socket
+	path := filepath.Join(t.TempDir(), "server.sock")
+	server, err = ServeAddr("unix", path, targetHandler)
+	require.NoError(t, err)
+	defer server.Close()
+	assert.Equal(t, path, server.Addr)
+	assert.Equal(t, "/where", get(t, "unix", path))
+
+	// Test: Existing listener
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server, err = ServeListener(ln, targetHandler)
+	require.NoError(t, err)
+	defer server.Close()
+	assert.Equal(t, ln.Addr().String(), server.Addr)
+	assert.Equal(t, "/where", get(t, "tcp", server.Addr))
+	assert.Error(t, server.StartListener(ln))
+
+	// Test: Listener inherited as a file descriptor
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f, err := ln.(*net.TCPListener).File()
+	require.NoError(t, err)
+	ln.Close()
+	ln, err = FileListener(f.Fd())
+	require.NoError(t, err)
+	f.Close()
+	server, err = ServeListener(ln, targetHandler)
+	require.NoError(t, err)
+	defer server.Close()
+	assert.Equal(t, "/where", get(t, "tcp", server.Addr))
+
+	// Test: Process not socket-activated has no systemd listeners
+	lns, err := SystemdListeners()
+	require.NoError(t, err)
+	assert.Empty(t, lns)
+}
+
+func TestServerExpectContinue(t *testing.T) {
+	server, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.Target.Path == "/reject" {
+			res := []byte("too large")
+			w.WriteStatusLine(response.StatusContentTooLarge)
+			w.WriteHeaders(response.GetDefaultHeaders(len(res)))
+			w.WriteBody(res)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	// Test: 100 Continue is sent once the handler reads the body
+	conn, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	require.NoError(t, err)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+	_, err = io.WriteString(conn, "hello")
+	require.NoError(t, err)
+	status, h, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.NotContains(t, h, "connection")
+	assert.Equal(t, "hello", body)
+
+	// Test: Handler rejecting the body skips the continue and closes the connection
+	_, err = io.WriteString(conn, "POST /reject HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	require.NoError(t, err)
+	status, h, body = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", status)
+	assert.Equal(t, "close", h["connection"])
+	assert.Equal(t, "too large", body)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Unknown expectation gets a 417
+	conn, err = net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nExpect: magic\r\n\r\n")
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 417 Expectation Failed", status)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether every connection
+// has finished.
+const shutdownPollInterval = 10 * time.Millisecond
+
+type connState int
+
+const (
+	// connIdle is a connection waiting for its next request.
+	connIdle connState = iota
+	// connActive is a connection whose request is being handled.
+	connActive
+)
+
+// Shutdown stops the server gracefully. It closes the listener, closes
+// connections that are idle between requests, and waits for the active ones
+// to finish their current response, after which they are closed too.
+//
+// If ctx expires first, the remaining connections are closed and the context's
+// error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Running.Store(false)
+	err := s.ln.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// trackConn records a newly accepted connection as idle. It returns false if
+// the server is stopping, in which case the connection must not be served.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Running.Load() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connState)
+	}
+	s.conns[conn] = connIdle
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+// setConnState records the new state of conn. It returns false if the
+// connection was closed by Shutdown or Close and must not be used anymore.
+func (s *Server) setConnState(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conns[conn]; !ok {
+		return false
+	}
+	if state == connIdle && !s.Running.Load() {
+		return false
+	}
+	s.conns[conn] = state
+	return true
+}
+
+// closeIdleConns closes every idle connection and reports whether no
+// connection is left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if state == connIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+)
+
+// ServeTLS is like Serve, but accepts HTTPS connections using the certificate
+// and key in the given PEM files.
+func ServeTLS(port int, certFile, keyFile string, handler Handler) (*Server, error) {
+	server := New(handler)
+	if err := server.StartTLS(port, certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// StartTLS is like Start, but terminates TLS on the connections. certFile and
+// keyFile name PEM files holding the certificate chain and its key; they may be
+// empty if TLSConfig already holds a certificate.
+func (s *Server) StartTLS(port int, certFile, keyFile string) error {
+	return s.StartAddrTLS("tcp", fmt.Sprintf("localhost:%d", port), certFile, keyFile)
+}
+
+// StartAddrTLS is like StartAddr, but terminates TLS on the connections as
+// StartTLS does.
+func (s *Server) StartAddrTLS(network, address, certFile, keyFile string) error {
+	if s.ln != nil {
+		return errors.New("server already started")
+	}
+
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+
+	return s.StartListener(tls.NewListener(ln, config))
+}
+
+// StartListenerTLS is like StartListener, but terminates TLS on the
+// connections accepted from ln as StartTLS does.
+func (s *Server) StartListenerTLS(ln net.Listener, certFile, keyFile string) error {
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	return s.StartListener(tls.NewListener(ln, config))
+}
+
+func (s *Server) tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("tls: no certificate configured")
+	}
+
+	if !slices.Contains(config.NextProtos, "http/1.1") {
+		config.NextProtos = append(slices.Clip(config.NextProtos), "http/1.1")
+	}
+
+	return config, nil
+}
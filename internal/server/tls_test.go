@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSigned writes a self-signed certificate for localhost and its key to PEM
+// files and returns their paths, along with a pool trusting the certificate.
+func selfSigned(t *testing.T) (string, string, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return certFile, keyFile, pool
+}
+
+func TestServerTLS(t *testing.T) {
+	certFile, keyFile, pool := selfSigned(t)
+
+	server, err := ServeTLS(0, certFile, keyFile, targetHandler)
+	require.NoError(t, err)
+	defer server.Close()
+
+	// Test: Requests are served over TLS with http/1.1 negotiated by ALPN
+	conn, err := tls.Dial("tcp", server.Addr, &tls.Config{
+		RootCAs:    pool,
+		ServerName: "localhost",
+		NextProtos: []string{"h2", "http/1.1"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "http/1.1", conn.ConnectionState().NegotiatedProtocol)
+
+	r := bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "GET /secure HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, _, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/secure", body)
+
+	// Test: Keep-alive works over TLS
+	_, err = io.WriteString(conn, "GET /again HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/again", body)
+
+	// Test: Plain HTTP on the TLS port is not served
+	plain, err := net.Dial("tcp", server.Addr)
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = io.WriteString(plain, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	plain.SetReadDeadline(time.Now().Add(2 * time.Second))
+	res, _ := io.ReadAll(plain)
+	assert.NotContains(t, string(res), "200 OK")
+
+	// Test: Starting without a certificate fails
+	err = New(targetHandler).StartTLS(0, "", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,347 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+)
+
+// maxDrainBytes is how much of an unread body Close will discard to keep the
+// connection usable for the next message.
+const maxDrainBytes = 256 << 10
+
+// maxChunkLineBytes bounds a chunk-size line, extensions included.
+const maxChunkLineBytes = 4096
+
+var (
+	// ErrClosed is returned by reads on a closed body.
+	ErrClosed = errors.New("read on closed body")
+	// ErrBodyTooLarge is returned when a chunked body exceeds
+	// Limits.MaxBodyBytes.
+	ErrBodyTooLarge = errors.New("body too large")
+	// ErrTrailersTooLarge is returned when the trailers exceed
+	// Limits.MaxTrailerBytes or Limits.MaxTrailerCount.
+	ErrTrailersTooLarge = errors.New("trailer fields too large")
+)
+
+type state int
+
+const (
+	stateContent state = iota
+	stateChunkSize
+	stateChunkData
+	stateChunkDataEnd
+	stateTrailers
+	// stateUntilClose reads a body that ends when the connection is closed.
+	stateUntilClose
+	stateDone
+)
+
+// Limits bounds a chunked body. A zero field means no limit.
+type Limits struct {
+	// MaxBodyBytes bounds the sum of the chunk sizes.
+	MaxBodyBytes int64
+	// MaxTrailerBytes bounds the trailer section, CRLFs included.
+	MaxTrailerBytes int
+	// MaxTrailerCount bounds the number of trailer field lines.
+	MaxTrailerCount int
+}
+
+// Body reads a message body from a Buffer on demand, decoding its framing:
+// a Content-Length, the chunked transfer coding or the end of the connection.
+type Body struct {
+	src       *Buffer
+	state     state
+	limits    Limits
+	remaining int64
+	bodyBytes int64
+	// trailerBytes counts the trailer section against the limits.
+	trailerBytes int
+	trailers     *headers.Headers
+	err          error
+	closed       bool
+	closeErr     error
+}
+
+// NewContentBody returns the body of a message with a Content-Length of n.
+func NewContentBody(src *Buffer, n int64) *Body {
+	b := &Body{src: src, state: stateContent, remaining: n}
+	if n == 0 {
+		b.state = stateDone
+	}
+	return b
+}
+
+// NewChunkedBody returns the body of a message with the chunked transfer
+// coding.
+func NewChunkedBody(src *Buffer, limits Limits) *Body {
+	return &Body{src: src, state: stateChunkSize, limits: limits}
+}
+
+// NewCloseDelimitedBody returns the body of a response that ends when the
+// server closes the connection.
+func NewCloseDelimitedBody(src *Buffer) *Body {
+	return &Body{src: src, state: stateUntilClose}
+}
+
+// CloseDelimited reports whether the body ends with the connection, which
+// then cannot carry another message.
+func (b *Body) CloseDelimited() bool {
+	return b.state == stateUntilClose
+}
+
+// Trailers returns the trailer fields sent after a chunked body, once the
+// body has been read to io.EOF. It is nil if there were none.
+func (b *Body) Trailers() *headers.Headers {
+	return b.trailers
+}
+
+func (b *Body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrClosed
+	}
+	return b.read(p)
+}
+
+func (b *Body) read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		if b.state == stateDone {
+			return 0, io.EOF
+		}
+
+		if b.src.readToIndex == 0 {
+			if n, ok := b.readDirect(p); ok {
+				return n, b.err
+			}
+			if b.state == stateDone {
+				continue
+			}
+		}
+
+		consumed, written, err := b.readNext(b.src.Bytes(), p)
+		if err != nil {
+			b.err = err
+			return 0, err
+		}
+		b.src.Consume(consumed)
+		if written > 0 {
+			return written, nil
+		}
+		if consumed > 0 {
+			continue
+		}
+
+		err = b.src.Fill()
+		if errors.Is(err, io.EOF) {
+			if b.state == stateUntilClose {
+				b.state = stateDone
+				return 0, io.EOF
+			}
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+}
+
+// readDirect reads body bytes from the connection straight into p, skipping
+// the buffer, when nothing is buffered and the state only expects body bytes.
+// It reports whether it applied.
+func (b *Body) readDirect(p []byte) (int, bool) {
+	switch b.state {
+	case stateContent, stateChunkData:
+		p = p[:min(int64(len(p)), b.remaining)]
+	case stateUntilClose:
+	default:
+		return 0, false
+	}
+
+	n, err := b.src.src.Read(p)
+	switch b.state {
+	case stateContent:
+		b.remaining -= int64(n)
+		if b.remaining == 0 {
+			b.state = stateDone
+		}
+	case stateChunkData:
+		b.remaining -= int64(n)
+		if b.remaining == 0 {
+			b.state = stateChunkDataEnd
+		}
+	}
+
+	switch {
+	case errors.Is(err, io.EOF) && b.state == stateUntilClose:
+		b.state = stateDone
+		if n == 0 {
+			return 0, false
+		}
+	case errors.Is(err, io.EOF) && b.state != stateDone:
+		b.err = io.ErrUnexpectedEOF
+	case err != nil && !errors.Is(err, io.EOF):
+		b.err = err
+	}
+	if n == 0 && b.err == nil {
+		// nothing read and no error, let the caller try again
+		return 0, false
+	}
+	return n, true
+}
+
+// Close discards what is left of the body so the next message on the
+// connection can be read. It returns an error if the body could not be fully
+// consumed, in which case the connection must not be reused. A body delimited
+// by closing the connection is not drained.
+func (b *Body) Close() error {
+	if b.closed {
+		return b.closeErr
+	}
+	b.closed = true
+
+	if b.state == stateUntilClose {
+		b.closeErr = errors.New("close-delimited body not read to the end")
+		return b.closeErr
+	}
+
+	n, err := io.CopyN(io.Discard, readerFunc(b.read), maxDrainBytes+1)
+	switch {
+	case err == nil || n > maxDrainBytes:
+		b.closeErr = errors.New("body too large to discard")
+	case !errors.Is(err, io.EOF):
+		b.closeErr = err
+	}
+	return b.closeErr
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// readNext runs one step of the body states over data, copying any body bytes
+// into p. It returns how many bytes of data were consumed and how many were
+// written to p.
+func (b *Body) readNext(data, p []byte) (int, int, error) {
+	switch b.state {
+	case stateContent:
+		// anything past content-length belongs to the next message
+		n := copy(p, data[:min(int64(len(data)), b.remaining)])
+		b.remaining -= int64(n)
+		if b.remaining == 0 {
+			b.state = stateDone
+		}
+		return n, n, nil
+	case stateUntilClose:
+		n := copy(p, data)
+		return n, n, nil
+	case stateChunkSize:
+		read, size, err := b.parseChunkSize(data)
+		if err != nil {
+			return 0, 0, err
+		}
+		if read == 0 {
+			return 0, 0, nil
+		}
+		if size == 0 {
+			b.state = stateTrailers
+		} else {
+			b.remaining = size
+			b.state = stateChunkData
+		}
+		return read, 0, nil
+	case stateChunkData:
+		n := copy(p, data[:min(int64(len(data)), b.remaining)])
+		b.remaining -= int64(n)
+		if b.remaining == 0 {
+			b.state = stateChunkDataEnd
+		}
+		return n, n, nil
+	case stateChunkDataEnd:
+		if len(data) < 2 {
+			return 0, 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte("\r\n")) {
+			return 0, 0, errors.New("missing CRLF after chunk data")
+		}
+		b.state = stateChunkSize
+		return 2, 0, nil
+	case stateTrailers:
+		read, done, err := b.parseTrailers(data)
+		if err != nil {
+			return 0, 0, err
+		}
+		if done {
+			b.state = stateDone
+		}
+		return read, 0, nil
+	case stateDone:
+		return 0, 0, errors.New("error: trying to read data in a done state")
+	}
+
+	return 0, 0, errors.New("error: unknown state")
+}
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk extensions.
+func (b *Body) parseChunkSize(data []byte) (int, int64, error) {
+	idx := bytes.Index(data, []byte("\r\n"))
+	if idx > maxChunkLineBytes || idx == -1 && len(data) > maxChunkLineBytes {
+		return 0, 0, errors.New("chunk size line too long")
+	}
+	if idx == -1 {
+		return 0, 0, nil
+	}
+
+	line, _, _ := strings.Cut(string(data[:idx]), ";")
+	line = strings.TrimRight(line, " \t")
+	if len(line) == 0 {
+		return 0, 0, errors.New("missing chunk size")
+	}
+
+	size, err := strconv.ParseUint(line, 16, 63)
+	if err != nil {
+		return 0, 0, errors.New("invalid chunk size")
+	}
+
+	if max := b.limits.MaxBodyBytes; max > 0 && b.bodyBytes+int64(size) > max {
+		return 0, 0, ErrBodyTooLarge
+	}
+	b.bodyBytes += int64(size)
+
+	return idx + 2, int64(size), nil
+}
+
+func (b *Body) parseTrailers(data []byte) (int, bool, error) {
+	if b.trailers == nil {
+		b.trailers = headers.NewHeaders()
+	}
+
+	n, done, err := b.trailers.Parse(data)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if max := b.limits.MaxTrailerBytes; max > 0 && (b.trailerBytes+n > max || n == 0 && b.trailerBytes+len(data) > max) {
+		return 0, false, ErrTrailersTooLarge
+	}
+	if max := b.limits.MaxTrailerCount; max > 0 && b.trailers.Len() > max {
+		return 0, false, ErrTrailersTooLarge
+	}
+	b.trailerBytes += n
+
+	if done && b.trailers.Len() == 0 {
+		b.trailers = nil
+	}
+	return n, done, nil
+}
@@ -0,0 +1,84 @@
+package wire
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buffer(data string) *Buffer {
+	return NewBuffer(iotest.OneByteReader(strings.NewReader(data)), 8)
+}
+
+func TestBody(t *testing.T) {
+	// Test: Content-length body stops at its length, leaving the rest buffered
+	src := buffer("helloGET")
+	b := NewContentBody(src, 5)
+	data, err := io.ReadAll(b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	require.NoError(t, src.Fill())
+	assert.Equal(t, "G", string(src.Bytes()))
+
+	// Test: Short content-length body
+	b = NewContentBody(buffer("hel"), 5)
+	_, err = io.ReadAll(b)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Chunked body with extensions and trailers
+	b = NewChunkedBody(buffer("5;name=value\r\nhello\r\n7\r\n world!\r\n0\r\nX-Sum: abc\r\n\r\n"), Limits{})
+	data, err = io.ReadAll(b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(data))
+	assert.Equal(t, []string{"abc"}, b.Trailers().Values("x-sum"))
+
+	// Test: Chunked body without trailers has nil trailers
+	b = NewChunkedBody(buffer("3\r\nabc\r\n0\r\n\r\n"), Limits{})
+	data, err = io.ReadAll(b)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+	assert.Nil(t, b.Trailers())
+
+	// Test: Chunk sizes over 31 bits are accepted up to the body limit
+	b = NewChunkedBody(buffer("100000000\r\nabc"), Limits{})
+	_, err = io.ReadAll(b)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	b = NewChunkedBody(buffer("100000000\r\nabc"), Limits{MaxBodyBytes: 1 << 20})
+	_, err = io.ReadAll(b)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Trailers over the limits
+	b = NewChunkedBody(buffer("0\r\nX-A: 1\r\nX-B: 2\r\n\r\n"), Limits{MaxTrailerCount: 1})
+	_, err = io.ReadAll(b)
+	require.ErrorIs(t, err, ErrTrailersTooLarge)
+	b = NewChunkedBody(buffer("0\r\nX-Long: "+strings.Repeat("a", 64)+"\r\n\r\n"), Limits{MaxTrailerBytes: 32})
+	_, err = io.ReadAll(b)
+	require.ErrorIs(t, err, ErrTrailersTooLarge)
+
+	// Test: Invalid chunk framing
+	b = NewChunkedBody(buffer("zz\r\n"), Limits{})
+	_, err = io.ReadAll(b)
+	require.Error(t, err)
+	b = NewChunkedBody(buffer("3\r\nabcX\r\n"), Limits{})
+	_, err = io.ReadAll(b)
+	require.Error(t, err)
+
+	// Test: Close-delimited body ends with the connection
+	b = NewCloseDelimitedBody(buffer("all of it"))
+	assert.True(t, b.CloseDelimited())
+	data, err = io.ReadAll(b)
+	require.NoError(t, err)
+	assert.Equal(t, "all of it", string(data))
+
+	// Test: Close drains what is left, except for close-delimited bodies
+	b = NewChunkedBody(buffer("3\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\n"), Limits{})
+	require.NoError(t, b.Close())
+	assert.Equal(t, []string{"1"}, b.Trailers().Values("x-sum"))
+	_, err = b.Read(make([]byte, 1))
+	require.ErrorIs(t, err, ErrClosed)
+	assert.Error(t, NewCloseDelimitedBody(buffer("rest")).Close())
+}
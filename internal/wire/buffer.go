@@ -0,0 +1,49 @@
+package wire
+
+import "io"
+
+// Buffer buffers the reads from a connection for the request and response
+// parsers. Bytes read past the end of one message are kept for the next.
+type Buffer struct {
+	src         io.Reader
+	buf         []byte
+	readToIndex int
+}
+
+// NewBuffer returns a buffer reading from src, starting with size bytes.
+func NewBuffer(src io.Reader, size int) *Buffer {
+	return &Buffer{
+		src: src,
+		buf: make([]byte, size),
+	}
+}
+
+// Bytes returns the bytes read but not consumed yet.
+func (b *Buffer) Bytes() []byte {
+	return b.buf[:b.readToIndex]
+}
+
+// Consume drops the first n buffered bytes, once parsed.
+func (b *Buffer) Consume(n int) {
+	copy(b.buf, b.buf[n:b.readToIndex])
+	b.readToIndex -= n
+}
+
+// Fill reads more data from the connection, doubling the buffer when it is
+// already full. The limits checked while parsing keep it from growing past
+// about twice the longest line allowed.
+func (b *Buffer) Fill() error {
+	if b.readToIndex == len(b.buf) {
+		newBuf := make([]byte, len(b.buf)*2)
+		copy(newBuf, b.buf)
+		b.buf = newBuf
+	}
+
+	read, err := b.src.Read(b.buf[b.readToIndex:])
+	b.readToIndex += read
+	if read > 0 {
+		// parse what we got before handling the error on the next read
+		return nil
+	}
+	return err
+}